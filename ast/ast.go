@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	"go.creack.net/gosh2/lexer"
 )
@@ -9,6 +10,11 @@ import (
 // Structure following the posix shell grammar as defined in
 //   https://pubs.opengroup.org/onlinepubs/9699919799/utilities/V3_chap02.html#tag_18_10_02
 
+// tokenString returns the shell representation of the given operator or separator.
+func tokenString(t lexer.TokenType) string {
+	return lexer.Token{Type: t}.PrettyPrint()
+}
+
 // Program represents the top-level program.
 type Program struct {
 	Commands []CompleteCommand // Represents a list of complete commands, i.e. separated by newlines.
@@ -31,7 +37,7 @@ type CompleteCommand struct {
 func (c CompleteCommand) Dump() string {
 	str := c.List.Dump()
 	if c.Separator != lexer.TokEOF && c.Separator != lexer.TokError {
-		str += c.Separator.String()
+		str += tokenString(c.Separator)
 	}
 	return str
 }
@@ -47,7 +53,7 @@ func (l List) Dump() string {
 	if l.Left == nil {
 		return l.Right.Dump()
 	}
-	return fmt.Sprintf("%s%s %s", l.Left.Dump(), l.Separator, l.Right.Dump())
+	return fmt.Sprintf("%s%s %s", l.Left.Dump(), tokenString(l.Separator), l.Right.Dump())
 }
 
 // AndOr represents a pipeline or pipelines connected with && or ||.
@@ -61,7 +67,7 @@ func (a AndOr) Dump() string {
 	if a.Left == nil {
 		return a.Right.Dump()
 	}
-	return fmt.Sprintf("%s %s %s", a.Left.Dump(), a.Separator, a.Right.Dump())
+	return fmt.Sprintf("%s %s %s", a.Left.Dump(), tokenString(a.Separator), a.Right.Dump())
 }
 
 type Pipeline struct {
@@ -100,6 +106,21 @@ type Command interface {
 	IORedirects() []IORedirect
 }
 
+// FunctionDefinition : fname '(' ')' linebreak function_body.
+type FunctionDefinition struct {
+	Name string
+	Body *CompoundCommandWrap // function_body, with its redirections applied on each call.
+}
+
+func (FunctionDefinition) command() {}
+
+// IORedirects returns nil as the redirections of the body only apply when the function is called.
+func (FunctionDefinition) IORedirects() []IORedirect { return nil }
+
+func (f FunctionDefinition) Dump() string {
+	return f.Name + "() " + f.Body.Dump()
+}
+
 type CompoundCommand interface {
 	Dump() string
 	compoundCommand()
@@ -133,6 +154,131 @@ func (s SubshellCommand) Dump() string {
 	return fmt.Sprintf("(%s)", s.Right.Dump())
 }
 
+// BraceGroup : Lbrace compound_list Rbrace.
+type BraceGroup struct {
+	Right *CompoundList
+}
+
+func (BraceGroup) compoundCommand() {}
+
+func (b BraceGroup) Dump() string {
+	return "{ " + b.Right.terminated() + " }"
+}
+
+// IfClause : If compound_list Then compound_list [else_part] Fi.
+type IfClause struct {
+	Condition *CompoundList
+	Body      *CompoundList
+	Else      *ElsePart // Nil without elif nor else.
+}
+
+func (IfClause) compoundCommand() {}
+
+func (i IfClause) Dump() string {
+	out := "if " + i.Condition.terminated() + " then " + i.Body.terminated()
+	if i.Else != nil {
+		out += " " + i.Else.Dump()
+	}
+	return out + " fi"
+}
+
+// ElsePart : Elif compound_list Then compound_list [else_part] | Else compound_list.
+type ElsePart struct {
+	Condition *CompoundList // Nil for else.
+	Body      *CompoundList
+	Else      *ElsePart
+}
+
+func (e ElsePart) Dump() string {
+	if e.Condition == nil {
+		return "else " + e.Body.terminated()
+	}
+	out := "elif " + e.Condition.terminated() + " then " + e.Body.terminated()
+	if e.Else != nil {
+		out += " " + e.Else.Dump()
+	}
+	return out
+}
+
+// WhileClause : While compound_list do_group.
+type WhileClause struct {
+	Condition *CompoundList
+	Body      *CompoundList // do_group.
+}
+
+func (WhileClause) compoundCommand() {}
+
+func (w WhileClause) Dump() string {
+	return "while " + w.Condition.terminated() + " do " + w.Body.terminated() + " done"
+}
+
+// UntilClause : Until compound_list do_group.
+type UntilClause struct {
+	Condition *CompoundList
+	Body      *CompoundList // do_group.
+}
+
+func (UntilClause) compoundCommand() {}
+
+func (u UntilClause) Dump() string {
+	return "until " + u.Condition.terminated() + " do " + u.Body.terminated() + " done"
+}
+
+// ForClause : For name [in wordlist] do_group.
+type ForClause struct {
+	Name  string
+	In    bool   // False for `for name`, iterating over the positional parameters.
+	Words []Word // wordlist.
+	Body  *CompoundList
+}
+
+func (ForClause) compoundCommand() {}
+
+func (f ForClause) Dump() string {
+	out := "for " + f.Name
+	if f.In {
+		out += " in"
+		for _, w := range f.Words {
+			out += " " + w.Dump()
+		}
+	}
+	return out + "; do " + f.Body.terminated() + " done"
+}
+
+// CaseClause : Case WORD in case_list Esac.
+type CaseClause struct {
+	Word  Word
+	Items []CaseItem
+}
+
+func (CaseClause) compoundCommand() {}
+
+func (c CaseClause) Dump() string {
+	out := "case " + c.Word.Dump() + " in"
+	for _, item := range c.Items {
+		out += " " + item.Dump()
+	}
+	return out + " esac"
+}
+
+// CaseItem : ['('] pattern ')' [compound_list] DSEMI.
+type CaseItem struct {
+	Patterns []Word
+	Body     *CompoundList // Nil if empty.
+}
+
+func (c CaseItem) Dump() string {
+	patterns := make([]string, 0, len(c.Patterns))
+	for _, p := range c.Patterns {
+		patterns = append(patterns, p.Dump())
+	}
+	out := "(" + strings.Join(patterns, "|") + ")"
+	if c.Body != nil {
+		out += " " + c.Body.Dump()
+	}
+	return out + " ;;"
+}
+
 type CompoundList struct {
 	Term      *Term
 	Separator lexer.TokenType // separator.
@@ -141,11 +287,20 @@ type CompoundList struct {
 func (c CompoundList) Dump() string {
 	out := c.Term.Dump()
 	if c.Separator != 0 {
-		out += c.Separator.String()
+		out += tokenString(c.Separator)
 	}
 	return out
 }
 
+// terminated dumps the list making sure it ends with a separator,
+// as needed before reserved words, i.e. `then` or `fi`.
+func (c CompoundList) terminated() string {
+	if c.Separator != 0 {
+		return c.Dump()
+	}
+	return c.Dump() + ";"
+}
+
 type Term struct {
 	Left      *Term
 	Separator lexer.TokenType // separator.
@@ -156,13 +311,13 @@ func (t Term) Dump() string {
 	if t.Left == nil {
 		return t.Right.Dump()
 	}
-	return fmt.Sprintf("%s %s %s", t.Left.Dump(), t.Separator, t.Right.Dump())
+	return fmt.Sprintf("%s%s %s", t.Left.Dump(), tokenString(t.Separator), t.Right.Dump())
 }
 
 // SimpleCommand represents a basic command with name, arguments and redirections.
 type SimpleCommand struct {
 	Prefix *CmdPrefix
-	Name   string // cmd_word or cmd_name.
+	Name   Word // cmd_word or cmd_name.
 	Suffix *CmdSuffix
 }
 
@@ -187,7 +342,7 @@ func (s SimpleCommand) Dump() string {
 	if out != "" {
 		out += " "
 	}
-	out += s.Name
+	out += s.Name.Dump()
 	if s.Suffix != nil {
 		out += " " + s.Suffix.Dump()
 	}
@@ -198,21 +353,21 @@ func (s SimpleCommand) command() {}
 
 type CmdPrefix struct {
 	Left           *CmdPrefix
-	AssignmentWord string
+	AssignmentWord *Assignment
 	Redir          *IORedirect
 }
 
-func (c CmdPrefix) AssignmentWords() []string {
-	if c.Left == nil && c.AssignmentWord == "" {
+func (c CmdPrefix) AssignmentWords() []Assignment {
+	if c.Left == nil && c.AssignmentWord == nil {
 		return nil
 	}
 	if c.Left == nil {
-		return []string{c.AssignmentWord}
+		return []Assignment{*c.AssignmentWord}
 	}
-	if c.AssignmentWord == "" {
+	if c.AssignmentWord == nil {
 		return c.Left.AssignmentWords()
 	}
-	return append(c.Left.AssignmentWords(), c.AssignmentWord)
+	return append(c.Left.AssignmentWords(), *c.AssignmentWord)
 }
 
 func (c CmdPrefix) IORedirects() []IORedirect {
@@ -230,13 +385,15 @@ func (c CmdPrefix) IORedirects() []IORedirect {
 
 func (c CmdPrefix) Dump() string {
 	if c.Left == nil {
-		if c.AssignmentWord != "" {
-			return c.AssignmentWord
+		if c.AssignmentWord != nil {
+			return c.AssignmentWord.Dump()
 		}
 		return c.Redir.Dump()
 	}
-	str := c.AssignmentWord
-	if str == "" {
+	var str string
+	if c.AssignmentWord != nil {
+		str = c.AssignmentWord.Dump()
+	} else {
 		str = c.Redir.Dump()
 	}
 	return fmt.Sprintf("%s %s", c.Left.Dump(), str)
@@ -244,18 +401,18 @@ func (c CmdPrefix) Dump() string {
 
 type CmdSuffix struct {
 	Left  *CmdSuffix
-	Word  string
+	Word  Word
 	Redir *IORedirect
 }
 
-func (c CmdSuffix) Words() []string {
-	if c.Left == nil && c.Word == "" {
+func (c CmdSuffix) Words() []Word {
+	if c.Left == nil && c.Word == nil {
 		return nil
 	}
 	if c.Left == nil {
-		return []string{c.Word}
+		return []Word{c.Word}
 	}
-	if c.Word == "" {
+	if c.Word == nil {
 		return c.Left.Words()
 	}
 	return append(c.Left.Words(), c.Word)
@@ -276,13 +433,13 @@ func (c CmdSuffix) IORedirects() []IORedirect {
 
 func (c CmdSuffix) Dump() string {
 	if c.Left == nil {
-		if c.Word != "" {
-			return c.Word
+		if c.Word != nil {
+			return c.Word.Dump()
 		}
 		return c.Redir.Dump()
 	}
-	str := c.Word
-	if str == "" {
+	str := c.Word.Dump()
+	if c.Word == nil {
 		str = c.Redir.Dump()
 	}
 	return fmt.Sprintf("%s %s", c.Left.Dump(), str)
@@ -300,13 +457,17 @@ func (i IORedirect) Dump() string {
 // IOFile represents io_file and io_here.
 type IOFile struct {
 	Operator lexer.TokenType // "<", ">", ">>", "|&", etc.
-	Filename string          // Filename or hereend.
+	Filename Word            // Filename or hereend.
 	ToNumber *int            // For n>&m, nil if not specified.
+	Close    bool            // For n>&- and n<&-.
 }
 
 func (i IOFile) Dump() string {
 	if i.ToNumber != nil {
 		return fmt.Sprintf("%s%d", i.Operator, *i.ToNumber)
 	}
-	return fmt.Sprintf("%s%s", i.Operator, i.Filename)
+	if i.Close {
+		return fmt.Sprintf("%s-", i.Operator)
+	}
+	return fmt.Sprintf("%s%s", i.Operator, i.Filename.Dump())
 }
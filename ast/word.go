@@ -0,0 +1,130 @@
+package ast
+
+import (
+	"strings"
+)
+
+// Word represents a shell word, i.e. a sequence of parts
+// concatenated together and expanded at execution time.
+type Word []WordPart
+
+// WordPart represents a part of a word.
+type WordPart interface {
+	Dump() string
+	wordPart()
+}
+
+func (w Word) Dump() string {
+	out := ""
+	for _, p := range w {
+		out += p.Dump()
+	}
+	return out
+}
+
+// Lit returns the literal value of the word if it doesn't have any
+// quoted or expandable part.
+func (w Word) Lit() (string, bool) {
+	out := ""
+	for _, p := range w {
+		l, ok := p.(*Literal)
+		if !ok || strings.Contains(l.Value, `\`) {
+			return "", false
+		}
+		out += l.Value
+	}
+	return out, true
+}
+
+// Literal represents a literal part of a word, as in the source.
+// Backslashes are kept, quoting the next character. Within double quotes,
+// they only do so before '$', '`', '"', '\' and newline.
+type Literal struct {
+	Value string
+}
+
+func (Literal) wordPart() {}
+
+func (l Literal) Dump() string {
+	return l.Value
+}
+
+// SingleQuoted represents a single quoted string, i.e. 'value'.
+type SingleQuoted struct {
+	Value string
+}
+
+func (SingleQuoted) wordPart() {}
+
+func (s SingleQuoted) Dump() string {
+	return "'" + s.Value + "'"
+}
+
+// DoubleQuoted represents a double quoted string, i.e. "parts".
+// Only literals, parameter expansions, command substitutions
+// and arithmetic expansions are found within.
+type DoubleQuoted struct {
+	Parts Word
+}
+
+func (DoubleQuoted) wordPart() {}
+
+func (d DoubleQuoted) Dump() string {
+	return `"` + d.Parts.Dump() + `"`
+}
+
+// ParamExpansion represents a parameter expansion, i.e. $name or ${name<op>word}.
+type ParamExpansion struct {
+	Name   string
+	Op     string // Operator, i.e. ":-", "=", "#", "%%", etc. Empty for simple expansions.
+	Word   Word   // Operand of the operator.
+	Length bool   // True for ${#name}.
+}
+
+func (ParamExpansion) wordPart() {}
+
+func (p ParamExpansion) Dump() string {
+	out := "${"
+	if p.Length {
+		out += "#"
+	}
+	return out + p.Name + p.Op + p.Word.Dump() + "}"
+}
+
+// CmdSubstitution represents a command substitution, i.e. $(cmd) or `cmd`.
+// The command is kept as source and only run when the word is expanded.
+type CmdSubstitution struct {
+	Cmd      string
+	Backtick bool // True for the `cmd` form.
+}
+
+func (CmdSubstitution) wordPart() {}
+
+func (c CmdSubstitution) Dump() string {
+	if c.Backtick {
+		return "`" + c.Cmd + "`"
+	}
+	return "$(" + c.Cmd + ")"
+}
+
+// ArithExpansion represents an arithmetic expansion, i.e. $((expr)).
+// The expression is expanded as if double quoted before being evaluated.
+type ArithExpansion struct {
+	Expr Word
+}
+
+func (ArithExpansion) wordPart() {}
+
+func (a ArithExpansion) Dump() string {
+	return "$((" + a.Expr.Dump() + "))"
+}
+
+// Assignment represents an assignment word, i.e. name=value.
+type Assignment struct {
+	Name  string
+	Value Word
+}
+
+func (a Assignment) Dump() string {
+	return a.Name + "=" + a.Value.Dump()
+}
@@ -0,0 +1,359 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arithOps are the operators of arithmetic expressions.
+// NOTE: Order matters as the first matching prefix wins.
+var arithOps = []string{
+	"<<=", ">>=",
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"*=", "/=", "%=", "+=", "-=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">", "=", "?", ":", "(", ")",
+}
+
+// arithPrecedence is the precedence of the binary operators, higher binding tighter.
+var arithPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// arithExpansion evaluates the given arithmetic expression, already expanded,
+// with signed 64 bits integers. Variables are referenced by name, unset or null ones being 0,
+// and can be assigned with the assignment operators.
+func arithExpansion(sh *Shell, expr string) (int64, error) {
+	v, err := evalArith(sh, expr)
+	var exitErr *ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, &ExitError{Code: 1, Err: fmt.Errorf("arithmetic expression %q: %w", expr, err)}
+	}
+	return v, err
+}
+
+// evalArith parses and evaluates the given arithmetic expression.
+func evalArith(sh *Shell, expr string) (int64, error) {
+	tokens, err := tokenizeArith(expr)
+	if err != nil {
+		return 0, err
+	}
+	p := &arithParser{sh: sh, tokens: tokens}
+	v, err := p.parseAssignment(true)
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != "" {
+		return 0, fmt.Errorf("syntax error: unexpected %q", p.peek())
+	}
+	return v, nil
+}
+
+// tokenizeArith splits the given arithmetic expression into numbers, names and operators.
+func tokenizeArith(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case isArithWordChar(c):
+			j := i + 1
+			for j < len(expr) && isArithWordChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+			continue
+		}
+		op := ""
+		for _, elem := range arithOps {
+			if strings.HasPrefix(expr[i:], elem) {
+				op = elem
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("syntax error: unexpected %q", expr[i:])
+		}
+		tokens = append(tokens, op)
+		i += len(op)
+	}
+	return tokens, nil
+}
+
+// isArithWordChar returns true if the given character is part of a number or a name.
+func isArithWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseArithNumber parses the given integer constant: decimal, octal with a leading 0
+// or hexadecimal with a leading 0x. Values overflowing int64 wrap around.
+func parseArithNumber(s string) (int64, error) {
+	digits, base := s, 10
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X"):
+		digits, base = s[2:], 16
+	case len(s) > 1 && s[0] == '0':
+		digits, base = s[1:], 8
+	}
+	// NOTE: ParseUint doesn't allow signs nor underscores with an explicit base.
+	n, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return int64(n), nil
+}
+
+// arithParser evaluates an arithmetic expression while parsing it, by recursive descent.
+// The operands not evaluated, i.e. the right side of a short-circuited && or ||
+// and the branch not taken of ?:, are only parsed: no assignment nor error occurs.
+type arithParser struct {
+	sh     *Shell
+	tokens []string
+	pos    int
+}
+
+// peek returns the current token, empty at the end of the expression.
+func (p *arithParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves to the next one.
+func (p *arithParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// expect consumes the given token, failing if it is not the current one.
+func (p *arithParser) expect(tok string) error {
+	if p.peek() != tok {
+		if p.peek() == "" {
+			return fmt.Errorf("syntax error: expected %q", tok)
+		}
+		return fmt.Errorf("syntax error: unexpected %q, expected %q", p.peek(), tok)
+	}
+	p.pos++
+	return nil
+}
+
+// parseAssignment parses an assignment, right associative, or a conditional expression.
+func (p *arithParser) parseAssignment(eval bool) (int64, error) {
+	if p.pos+1 < len(p.tokens) && isName(p.tokens[p.pos]) && isArithAssignOp(p.tokens[p.pos+1]) {
+		name, op := p.next(), p.next()
+		v, err := p.parseAssignment(eval)
+		if err != nil || !eval {
+			return 0, err
+		}
+		if op != "=" {
+			cur, err := p.variable(name, eval)
+			if err != nil {
+				return 0, err
+			}
+			if v, err = arithBinary(strings.TrimSuffix(op, "="), cur, v); err != nil {
+				return 0, err
+			}
+		}
+		if err := p.sh.setVar(name, strconv.FormatInt(v, 10)); err != nil {
+			return 0, &ExitError{Code: 1, Err: err}
+		}
+		return v, nil
+	}
+	return p.parseConditional(eval)
+}
+
+// isArithAssignOp returns true if the given token is an assignment operator.
+func isArithAssignOp(tok string) bool {
+	return tok == "=" || (len(tok) >= 2 && strings.HasSuffix(tok, "=") && arithPrecedence[tok] == 0)
+}
+
+// parseConditional parses a cond ? a : b expression, right associative,
+// or a binary expression.
+func (p *arithParser) parseConditional(eval bool) (int64, error) {
+	cond, err := p.parseBinary(1, eval)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.next()
+	a, err := p.parseAssignment(eval && cond != 0)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.expect(":"); err != nil {
+		return 0, err
+	}
+	b, err := p.parseConditional(eval && cond == 0)
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return a, nil
+	}
+	return b, nil
+}
+
+// parseBinary parses a sequence of left associative binary operations
+// with at least the given precedence, by precedence climbing.
+func (p *arithParser) parseBinary(minPrecedence int, eval bool) (int64, error) {
+	left, err := p.parseUnary(eval)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		precedence, ok := arithPrecedence[op]
+		if !ok || precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		// && and || don't evaluate their right side when the left one determines the result.
+		evalRight := eval && !(op == "&&" && left == 0) && !(op == "||" && left != 0)
+		right, err := p.parseBinary(precedence+1, evalRight)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case op == "&&":
+			left = boolToInt(left != 0 && right != 0)
+		case op == "||":
+			left = boolToInt(left != 0 || right != 0)
+		case !eval:
+			left = 0
+		default:
+			if left, err = arithBinary(op, left, right); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// parseUnary parses the unary operators + - ~ ! and a primary expression:
+// a number, a variable name or a parenthesized expression.
+func (p *arithParser) parseUnary(eval bool) (int64, error) {
+	switch tok := p.next(); {
+	case tok == "+", tok == "-", tok == "~", tok == "!":
+		v, err := p.parseUnary(eval)
+		if err != nil {
+			return 0, err
+		}
+		switch tok {
+		case "-":
+			return -v, nil
+		case "~":
+			return ^v, nil
+		case "!":
+			return boolToInt(v == 0), nil
+		}
+		return v, nil
+	case tok == "(":
+		v, err := p.parseAssignment(eval)
+		if err != nil {
+			return 0, err
+		}
+		return v, p.expect(")")
+	case tok != "" && tok[0] >= '0' && tok[0] <= '9':
+		return parseArithNumber(tok)
+	case isName(tok):
+		return p.variable(tok, eval)
+	case tok == "":
+		return 0, fmt.Errorf("syntax error: operand expected")
+	default:
+		return 0, fmt.Errorf("syntax error: unexpected %q", tok)
+	}
+}
+
+// variable returns the value of the given variable as an integer constant.
+// Unset and null variables are 0.
+func (p *arithParser) variable(name string, eval bool) (int64, error) {
+	if !eval {
+		return 0, nil
+	}
+	v, err := lookupSetParam(p.sh, name)
+	if err != nil {
+		return 0, err
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, nil
+	}
+	sign := int64(1)
+	if v[0] == '-' || v[0] == '+' {
+		if v[0] == '-' {
+			sign = -1
+		}
+		v = v[1:]
+	}
+	n, err := parseArithNumber(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return sign * n, nil
+}
+
+// arithBinary applies the given binary operator, other than && and ||.
+// Shift counts are taken modulo 64.
+func arithBinary(op string, a, b int64) (int64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "<<":
+		return a << (uint64(b) & 63), nil
+	case ">>":
+		return a >> (uint64(b) & 63), nil
+	case "&":
+		return a & b, nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "==":
+		return boolToInt(a == b), nil
+	case "!=":
+		return boolToInt(a != b), nil
+	case "<":
+		return boolToInt(a < b), nil
+	case "<=":
+		return boolToInt(a <= b), nil
+	case ">":
+		return boolToInt(a > b), nil
+	case ">=":
+		return boolToInt(a >= b), nil
+	}
+	return 0, fmt.Errorf("unsupported operator %q", op)
+}
+
+// boolToInt returns 1 for true and 0 for false.
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
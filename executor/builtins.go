@@ -1,189 +1,179 @@
 package executor
 
-// type builtinCmd struct {
-// 	simpleCmd ast.SimpleCommand
-// 	output    string
-
-// 	stdin  io.Reader
-// 	stdout io.Writer
-// 	stderr io.Writer
-
-// 	extraFiles []*os.File
-
-// 	exitCode int
-// }
-
-// func newBuiltinCmd(cmd ast.SimpleCommand) *builtinCmd {
-// 	return &builtinCmd{
-// 		simpleCmd: cmd,
-// 	}
-// }
-
-// func (c *builtinCmd) GetStdin() io.Reader  { return c.stdin }
-// func (c *builtinCmd) GetStdout() io.Writer { return c.stdout }
-// func (c *builtinCmd) GetStderr() io.Writer { return c.stderr }
-
-// func (c *builtinCmd) SetStdin(stdin io.Reader)   { c.stdin = stdin }
-// func (c *builtinCmd) SetStdout(stdout io.Writer) { c.stdout = stdout }
-// func (c *builtinCmd) SetStderr(stderr io.Writer) { c.stderr = stderr }
-
-// func (c *builtinCmd) GetExtraFD(n int) *os.File {
-// 	if len(c.extraFiles) > n-3 {
-// 		return c.extraFiles[n-3]
-// 	}
-// 	return nil
-// }
-// func (c *builtinCmd) SetExtraFD(n int, file *os.File) {
-// 	if len(c.extraFiles) <= n-3 {
-// 		extraFiles := make([]*os.File, n-3+1)
-// 		copy(extraFiles, c.extraFiles)
-// 		c.extraFiles = extraFiles
-// 	}
-// 	c.extraFiles[n-3] = file
-// }
-
-// func (c *builtinCmd) ExitCode() int {
-// 	return c.exitCode
-// }
-
-// func (c *builtinCmd) GetProcessState() Exiter {
-// 	return c
-// }
-
-// func (c *builtinCmd) GetPath() string { return c.simpleCmd.Name }
-
-// func (c *builtinCmd) StdoutPipe() (io.ReadCloser, error) {
-// 	r, w, err := os.Pipe()
-// 	if err != nil {
-// 		return nil, fmt.Errorf("pipe error: %w", err)
-// 	}
-// 	c.stdout = w
-// 	return r, nil
-// }
-
-// func (c *builtinCmd) Start() error {
-// 	if c.output != "" {
-// 		if _, err := io.WriteString(c.stdout, c.output); err != nil {
-// 			return err
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func (c *builtinCmd) Wait() error {
-// 	if c.stdout != nil {
-// 		closer, _ := c.stdout.(io.Closer)
-// 		if closer != nil {
-// 			return closer.Close()
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func newBuiltinEcho(scmd ast.SimpleCommand) *builtinCmd {
-// 	cmd := newBuiltinCmd(scmd)
-// 	cmd.output = strings.Join(cmd.simpleCmd.Suffix.Words, " ") + "\n"
-// 	return cmd
-// }
-
-// func newBuiltinEnv(scmd ast.SimpleCommand) *builtinCmd {
-// 	cmd := newBuiltinCmd(scmd)
-// 	//env := append(os.Environ(), scmd.Prefix.Assignments...)
-// 	env := os.Environ()
-
-// 	output := strings.Join(env, "\n") + "\n"
-// 	cmd.output = output
-
-// 	return cmd
-// }
-
-// func newBuiltinGetenv(scmd ast.SimpleCommand) *builtinCmd {
-// 	cmd := newBuiltinCmd(scmd)
-// 	//env := append(os.Environ(), scmd.Prefix.Assignments...)
-// 	env := os.Environ()
-// 	ret := ""
-// 	for _, elem := range env {
-// 		if strings.HasPrefix(elem, scmd.Suffix.Words[0]+"=") {
-// 			ret = strings.TrimPrefix(elem, scmd.Suffix.Words[0]+"=")
-// 			break
-// 		}
-// 	}
-// 	if ret != "" {
-// 		ret += "\n"
-// 	}
-// 	cmd.output = ret
-
-// 	return cmd
-// }
-
-// type builtinExit struct {
-// 	*builtinCmd
-// }
-
-// func (c *builtinExit) Start() error {
-// 	if len(c.simpleCmd.Suffix.Words) == 0 {
-// 		os.Exit(0)
-// 		return nil
-// 	}
-// 	n, err := strconv.Atoi(c.simpleCmd.Suffix.Words[0])
-// 	if err != nil {
-// 		return fmt.Errorf("invalid exit code %q: %w", c.simpleCmd.Suffix.Words[0], err)
-// 	}
-// 	os.Exit(n)
-// 	return nil
-// }
-
-// type builtinCD struct {
-// 	*builtinCmd
-// }
-
-// func (c *builtinCD) Start() error {
-// 	if len(c.simpleCmd.Suffix.Words) == 0 {
-// 		// TODO: Handle this. Go back to last dir.
-// 		return fmt.Errorf("cd: missing argument")
-// 	}
-// 	if err := os.Chdir(c.simpleCmd.Suffix.Words[0]); err != nil {
-// 		log.Printf("cd: %s", err)
-// 		return err
-// 	}
-// 	return nil
-// }
-
-// type builtinPWD struct {
-// 	*builtinCmd
-// }
-
-// func (c *builtinPWD) Start() error {
-// 	return nil
-// 	if len(c.simpleCmd.Suffix.Words) != 0 {
-// 		return fmt.Errorf("pwd: too many arguments")
-// 	}
-// 	dir, err := os.Getwd()
-// 	if err != nil {
-// 		return fmt.Errorf("pwd: %w", err)
-// 	}
-// 	c.output = dir + "\n"
-// 	return nil
-// }
-
-// func handleBuiltinCmd(scmd ast.SimpleCommand) CmdIO {
-// 	switch scmd.Name {
-// 	case "echo":
-// 		return newBuiltinEcho(scmd)
-// 	case "env":
-// 		return newBuiltinEnv(scmd)
-// 	case "getenv":
-// 		return newBuiltinGetenv(scmd)
-// 	case "exit":
-// 		return &builtinExit{builtinCmd: newBuiltinCmd(scmd)}
-// 	case "cd":
-// 		return newBuiltinEcho(scmd)
-// 		out := &builtinCD{builtinCmd: newBuiltinCmd(scmd)}
-// 		out.output = "cd: " + strings.Join(scmd.Suffix.Words, " ") + "\n"
-// 		return out
-// 	case "pwd":
-// 		return &builtinPWD{builtinCmd: newBuiltinCmd(scmd)}
-// 	default:
-// 		return nil
-// 	}
-// }
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// builtinFunc is the implementation of a builtin command.
+// It returns the exit code of the builtin, and an error when the
+// builtin alters the control flow, i.e. exit, return, break or fatal errors.
+type builtinFunc func(sh *Shell, c *builtinCmd) (int, error)
+
+// specialBuiltins are the POSIX special builtins.
+// They are looked up before anything else and their prefix assignments persist in the shell.
+var specialBuiltins map[string]builtinFunc
+
+func init() {
+	// NOTE: Set in init to avoid initialization cycles as eval and . evaluate commands.
+	specialBuiltins = map[string]builtinFunc{
+		":":        builtinColon,
+		".":        builtinDot,
+		"break":    builtinBreak,
+		"continue": builtinContinue,
+		"eval":     builtinEval,
+		"exec":     builtinExec,
+		"exit":     builtinExit,
+		"export":   builtinExport,
+		"readonly": builtinReadonly,
+		"return":   builtinReturn,
+		"set":      builtinSet,
+		"shift":    builtinShift,
+		"times":    builtinTimes,
+		"trap":     builtinTrap,
+		"unset":    builtinUnset,
+	}
+}
+
+// withAssignments returns the given builtin with the given assignments,
+// and their expanded values, applied for the duration of the builtin only.
+func withAssignments(fn builtinFunc, assignments []ast.Assignment, values []string) builtinFunc {
+	if len(assignments) == 0 {
+		return fn
+	}
+	return func(sh *Shell, c *builtinCmd) (int, error) {
+		// Save the variables to restore them once done, nil if they didn't exist.
+		saved := map[string]*variable{}
+		for i, a := range assignments {
+			if _, ok := saved[a.Name]; !ok {
+				saved[a.Name] = nil
+				if v, ok := sh.vars[a.Name]; ok {
+					cpy := *v
+					saved[a.Name] = &cpy
+				}
+			}
+			if err := sh.setVar(a.Name, values[i]); err != nil {
+				return 1, &ExitError{Code: 1, Err: err}
+			}
+		}
+		defer func() {
+			for name, v := range saved {
+				if v == nil {
+					delete(sh.vars, name)
+				} else {
+					sh.vars[name] = v
+				}
+			}
+		}()
+		return fn(sh, c)
+	}
+}
+
+// builtinCmd runs a builtin command within the shell process.
+// The builtin is run in its own goroutine so it can be part of a pipeline.
+type builtinCmd struct {
+	sh   *Shell
+	fn   builtinFunc
+	args []string // Arguments, including the builtin name.
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	extraFiles []*os.File
+
+	pipeW *os.File // Write end of the stdout pipe, closed once the builtin is done.
+
+	exitCode int
+	err      error
+	done     chan struct{}
+}
+
+func newBuiltinCmd(sh *Shell, fn builtinFunc, args []string, stdin io.Reader, stderr io.Writer) *builtinCmd {
+	return &builtinCmd{
+		sh:     sh,
+		fn:     fn,
+		args:   args,
+		stdin:  stdin,
+		stderr: stderr,
+
+		extraFiles: sh.fds.extraFiles(),
+	}
+}
+
+func (c *builtinCmd) GetPath() string         { return c.args[0] }
+func (c *builtinCmd) GetStdin() io.Reader     { return c.stdin }
+func (c *builtinCmd) GetStdout() io.Writer    { return c.stdout }
+func (c *builtinCmd) GetStderr() io.Writer    { return c.stderr }
+func (c *builtinCmd) SetStdin(r io.Reader)    { c.stdin = r }
+func (c *builtinCmd) SetStdout(w io.Writer)   { c.stdout = w }
+func (c *builtinCmd) SetStderr(w io.Writer)   { c.stderr = w }
+func (c *builtinCmd) GetProcessState() Exiter { return c }
+func (c *builtinCmd) ExitCode() int           { return c.exitCode }
+
+func (c *builtinCmd) GetExtraFD(n int) *os.File {
+	if len(c.extraFiles) > n-3 {
+		return c.extraFiles[n-3]
+	}
+	return os.NewFile(uintptr(n), fmt.Sprintf("fd:%d", n))
+}
+
+func (c *builtinCmd) SetExtraFD(n int, file *os.File) {
+	if len(c.extraFiles) <= n-3 {
+		extraFiles := make([]*os.File, n-3+1)
+		copy(extraFiles, c.extraFiles)
+		c.extraFiles = extraFiles
+	}
+	c.extraFiles[n-3] = file
+}
+
+func (c *builtinCmd) StdoutPipe() (io.ReadCloser, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("pipe: %w", err)
+	}
+	c.stdout = w
+	c.pipeW = w
+	return r, nil
+}
+
+func (c *builtinCmd) Start() error {
+	if c.stdin == nil {
+		c.stdin = strings.NewReader("")
+	}
+	if c.stdout == nil {
+		c.stdout = io.Discard
+	}
+	if c.stderr == nil {
+		c.stderr = io.Discard
+	}
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.exitCode, c.err = c.fn(c.sh, c)
+		if c.pipeW != nil {
+			_ = c.pipeW.Close() // Best effort, signal EOF to the next command.
+		}
+	}()
+	return nil
+}
+
+func (c *builtinCmd) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// errorf reports an error message prefixed by the shell and builtin names on stderr.
+func (c *builtinCmd) errorf(format string, args ...any) {
+	fmt.Fprintf(c.stderr, "%s: %s: %s\n", c.sh.name, c.args[0], fmt.Sprintf(format, args...))
+}
+
+// fatalf returns an error making the shell exit with the given code,
+// used for the errors of special builtins.
+func (c *builtinCmd) fatalf(code int, format string, args ...any) error {
+	return &ExitError{Code: code, Err: fmt.Errorf("%s: %s", c.args[0], fmt.Sprintf(format, args...))}
+}
@@ -0,0 +1,373 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// regularBuiltins are the builtins looked up after the special builtins and functions,
+// before PATH. Their prefix assignments only apply for the duration of the builtin.
+var regularBuiltins = map[string]builtinFunc{
+	"cd":     builtinCd,
+	"pwd":    builtinPwd,
+	"read":   builtinRead,
+	"test":   builtinTest,
+	"[":      builtinTest,
+	"printf": builtinPrintf,
+	"echo":   builtinEcho,
+	"true":   builtinTrue,
+	"false":  builtinFalse,
+	"wait":   builtinWait,
+	"kill":   builtinKill,
+	"jobs":   builtinJobs,
+	"fg":     builtinFg,
+	"bg":     builtinBg,
+}
+
+// builtinTrue succeeds.
+func builtinTrue(*Shell, *builtinCmd) (int, error) { return 0, nil }
+
+// builtinFalse fails.
+func builtinFalse(*Shell, *builtinCmd) (int, error) { return 1, nil }
+
+// parsePhysicalFlag parses the -L and -P options of cd and pwd.
+// Returns true if the last one is -P, the remaining arguments and false on invalid option.
+func parsePhysicalFlag(c *builtinCmd) (bool, []string, bool) {
+	physical := false
+	args := c.args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			return physical, args[1:], true
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				c.errorf("-%c: invalid option", flag)
+				return false, nil, false
+			}
+		}
+		args = args[1:]
+	}
+	return physical, args, true
+}
+
+// builtinCd changes the current directory of the shell.
+// Without argument, goes to HOME, with "-", goes to OLDPWD.
+// Relative directories are looked up in CDPATH.
+func builtinCd(sh *Shell, c *builtinCmd) (int, error) {
+	physical, args, ok := parsePhysicalFlag(c)
+	if !ok {
+		return 2, nil
+	}
+	if len(args) > 1 {
+		c.errorf("too many arguments")
+		return 1, nil
+	}
+
+	var dir string
+	printDir := false
+	switch {
+	case len(args) == 0:
+		home, _ := sh.getVar("HOME")
+		if home == "" {
+			c.errorf("HOME not set")
+			return 1, nil
+		}
+		dir = home
+	case args[0] == "-":
+		oldpwd, _ := sh.getVar("OLDPWD")
+		if oldpwd == "" {
+			c.errorf("OLDPWD not set")
+			return 1, nil
+		}
+		dir, printDir = oldpwd, true
+	default:
+		dir = args[0]
+	}
+	operand := dir
+
+	// Look up relative directories not starting with a dot in CDPATH.
+	if !filepath.IsAbs(dir) && dir != "." && dir != ".." && !strings.HasPrefix(dir, "./") && !strings.HasPrefix(dir, "../") {
+		cdpath, _ := sh.getVar("CDPATH")
+		for _, prefix := range filepath.SplitList(cdpath) {
+			if prefix == "" {
+				prefix = "."
+			}
+			if fi, err := os.Stat(filepath.Join(prefix, dir)); err == nil && fi.IsDir() {
+				// Directories found through a non-empty CDPATH entry are printed.
+				printDir = printDir || prefix != "."
+				dir = filepath.Join(prefix, dir)
+				break
+			}
+		}
+	}
+
+	oldpwd := sh.pwd()
+	target := dir
+	if !physical && !filepath.IsAbs(target) {
+		// In logical mode, ".." is resolved against PWD rather than the physical directory.
+		target = filepath.Join(oldpwd, target)
+	}
+	if err := os.Chdir(target); err != nil {
+		c.errorf("%s: %s", operand, errors.Unwrap(err))
+		return 1, nil
+	}
+	newpwd := filepath.Clean(target)
+	if physical {
+		if wd, err := physicalWd(); err == nil {
+			newpwd = wd
+		}
+	}
+	if err := sh.setVar("OLDPWD", oldpwd); err != nil {
+		c.errorf("%s", err)
+		return 1, nil
+	}
+	if err := sh.setVar("PWD", newpwd); err != nil {
+		c.errorf("%s", err)
+		return 1, nil
+	}
+	if printDir {
+		fmt.Fprintln(c.stdout, newpwd)
+	}
+	return 0, nil
+}
+
+// physicalWd returns the current directory with all symlinks resolved.
+func physicalWd() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(wd)
+}
+
+// pwd returns the logical current directory, i.e. PWD
+// if it refers to the current directory, the physical one otherwise.
+func (sh *Shell) pwd() string {
+	if pwd, ok := sh.getVar("PWD"); ok && filepath.IsAbs(pwd) {
+		fi1, err1 := os.Stat(pwd)
+		fi2, err2 := os.Stat(".")
+		if err1 == nil && err2 == nil && os.SameFile(fi1, fi2) {
+			return pwd
+		}
+	}
+	wd, _ := physicalWd()
+	return wd
+}
+
+// builtinPwd prints the current directory, logical by default, physical with -P.
+func builtinPwd(sh *Shell, c *builtinCmd) (int, error) {
+	physical, _, ok := parsePhysicalFlag(c)
+	if !ok {
+		return 2, nil
+	}
+	wd := sh.pwd()
+	if physical {
+		var err error
+		if wd, err = physicalWd(); err != nil {
+			c.errorf("%s", err)
+			return 1, nil
+		}
+	}
+	fmt.Fprintln(c.stdout, wd)
+	return 0, nil
+}
+
+// builtinRead reads a line from stdin and splits it into the given variables using IFS.
+// The last variable gets the remainder of the line. Without -r, backslash escapes the next
+// character and removes newlines. Without variable, the line is stored in REPLY.
+func builtinRead(sh *Shell, c *builtinCmd) (int, error) {
+	raw := false
+	args := c.args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		if args[0] != "-r" {
+			c.errorf("%s: invalid option", args[0])
+			return 2, nil
+		}
+		raw = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		args = []string{"REPLY"}
+	}
+	for _, name := range args {
+		if !isName(name) {
+			c.errorf("`%s': not a valid identifier", name)
+			return 2, nil
+		}
+	}
+
+	line, escaped, err := readLine(c.stdin, raw)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.errorf("%s", err)
+		return 2, nil
+	}
+
+	fields := splitRead(line, escaped, sh.ifs(), len(args))
+	for i, name := range args {
+		value := ""
+		if i < len(fields) {
+			value = fields[i]
+		}
+		if err := sh.setVar(name, value); err != nil {
+			c.errorf("%s", err)
+			return 2, nil
+		}
+	}
+	// Reaching EOF before the end of the line is a failure.
+	if err != nil {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// readLine reads a single line from the given reader, one byte at a time
+// to not consume input past the newline. Unless raw, backslashes escape
+// the next character and backslash-newline pairs are removed.
+// Returns the line and which runes are escaped.
+func readLine(r io.Reader, raw bool) ([]rune, []bool, error) {
+	var (
+		buf     []byte
+		escapes []bool // Per byte.
+		b       [1]byte
+		escape  bool
+	)
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			line, escaped := toRunes(buf, escapes)
+			return line, escaped, err
+		}
+		switch {
+		case escape:
+			escape = false
+			if b[0] == '\n' {
+				continue
+			}
+			buf = append(buf, b[0])
+			escapes = append(escapes, true)
+		case b[0] == '\\' && !raw:
+			escape = true
+		case b[0] == '\n':
+			line, escaped := toRunes(buf, escapes)
+			return line, escaped, nil
+		default:
+			buf = append(buf, b[0])
+			escapes = append(escapes, false)
+		}
+	}
+}
+
+// toRunes converts the given bytes with per byte escape flags into runes with per rune flags.
+func toRunes(buf []byte, escapes []bool) ([]rune, []bool) {
+	var (
+		runes   []rune
+		escaped []bool
+	)
+	for i, r := range string(buf) {
+		runes = append(runes, r)
+		escaped = append(escaped, escapes[i])
+	}
+	return runes, escaped
+}
+
+// splitRead splits the given line in at most n fields using the given IFS, the last field
+// getting the remainder of the line. Escaped runes are never delimiters.
+func splitRead(line []rune, escaped []bool, ifs string, n int) []string {
+	isIFS := func(i int) bool { return !escaped[i] && strings.ContainsRune(ifs, line[i]) }
+	isIFSSpace := func(i int) bool { return isIFS(i) && strings.ContainsRune(" \t\n", line[i]) }
+
+	// Leading and trailing IFS whitespaces are ignored.
+	start, end := 0, len(line)
+	for start < end && isIFSSpace(start) {
+		start++
+	}
+	for end > start && isIFSSpace(end-1) {
+		end--
+	}
+
+	var fields []string
+	i := start
+	for i < end {
+		if len(fields) == n-1 {
+			// The last field is the remainder, without a trailing delimiter
+			// if it is made of a single field.
+			rest := i
+			for rest < end && !isIFS(rest) {
+				rest++
+			}
+			last := end
+			if rest == end-1 {
+				last = rest
+			}
+			fields = append(fields, string(line[i:last]))
+			return fields
+		}
+		j := i
+		for j < end && !isIFS(j) {
+			j++
+		}
+		fields = append(fields, string(line[i:j]))
+		// Skip the delimiter: IFS whitespaces around at most one non-whitespace IFS character.
+		for j < end && isIFSSpace(j) {
+			j++
+		}
+		if j < end && isIFS(j) && !isIFSSpace(j) {
+			j++
+			for j < end && isIFSSpace(j) {
+				j++
+			}
+		}
+		i = j
+	}
+	return fields
+}
+
+// builtinEcho prints its arguments separated by spaces.
+// -n omits the trailing newline, -e interprets the backslash escapes and -E doesn't.
+func builtinEcho(_ *Shell, c *builtinCmd) (int, error) {
+	newline, escapes := true, false
+	args := c.args[1:]
+	// Only arguments made of valid flags are options.
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	out := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		out, stop = expandEscapes(out, true)
+		if stop {
+			newline = false
+		}
+	}
+	if newline {
+		out += "\n"
+	}
+	if _, err := io.WriteString(c.stdout, out); err != nil {
+		c.errorf("write error: %s", err)
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,459 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// builtinColon does nothing and succeeds, only expanding its arguments.
+func builtinColon(*Shell, *builtinCmd) (int, error) { return 0, nil }
+
+// builtinDot evaluates the given file in the current shell.
+// Files without slash are looked up in PATH.
+func builtinDot(sh *Shell, c *builtinCmd) (int, error) {
+	if len(c.args) < 2 {
+		return 2, c.fatalf(2, "filename argument required")
+	}
+	filename := c.args[1]
+	if !strings.Contains(filename, "/") {
+		path, _ := sh.getVar("PATH")
+		found := false
+		for _, dir := range filepath.SplitList(path) {
+			if dir == "" {
+				dir = "."
+			}
+			p := filepath.Join(dir, filename)
+			if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+				filename, found = p, true
+				break
+			}
+		}
+		if !found {
+			return 1, c.fatalf(1, "%s: not found", c.args[1])
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return 1, c.fatalf(1, "%s: %s", c.args[1], errors.Unwrap(err))
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	sh.funcDepth++
+	defer func() { sh.funcDepth-- }()
+	exitCode, err := sh.evalInput(f, c.stdin, c.stdout, c.stderr)
+	var returnErr *returnError
+	if errors.As(err, &returnErr) {
+		return returnErr.code, nil
+	}
+	return exitCode, err
+}
+
+// builtinBreak exits from the n-th enclosing loop.
+func builtinBreak(sh *Shell, c *builtinCmd) (int, error) { return loopControl(sh, c, false) }
+
+// builtinContinue resumes the next iteration of the n-th enclosing loop.
+func builtinContinue(sh *Shell, c *builtinCmd) (int, error) { return loopControl(sh, c, true) }
+
+// loopControl implements break and continue.
+func loopControl(sh *Shell, c *builtinCmd, cont bool) (int, error) {
+	n := 1
+	if len(c.args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(c.args[1]); err != nil {
+			return 2, c.fatalf(2, "%s: numeric argument required", c.args[1])
+		}
+		if n < 1 {
+			c.errorf("%s: loop count out of range", c.args[1])
+			return 1, nil
+		}
+	}
+	// Outside of a loop, break and continue are ignored.
+	if sh.loopDepth == 0 {
+		return 0, nil
+	}
+	// Unwind as many loops as possible.
+	return 0, &loopError{n: min(n, sh.loopDepth), cont: cont}
+}
+
+// builtinEval evaluates its arguments, joined by spaces, in the current shell.
+func builtinEval(sh *Shell, c *builtinCmd) (int, error) {
+	return sh.evalInput(strings.NewReader(strings.Join(c.args[1:], " ")), c.stdin, c.stdout, c.stderr)
+}
+
+// builtinExec without command does nothing by itself, its redirections applying to the shell.
+// NOTE: The redirections and `exec cmd` are handled when evaluating the pipeline.
+func builtinExec(*Shell, *builtinCmd) (int, error) { return 0, nil }
+
+// builtinExit exits the shell with the given code, defaulting to the last exit code.
+func builtinExit(sh *Shell, c *builtinCmd) (int, error) {
+	code := sh.lastExitCode
+	if len(c.args) > 1 {
+		n, err := strconv.Atoi(c.args[1])
+		if err != nil {
+			return 2, c.fatalf(2, "%s: numeric argument required", c.args[1])
+		}
+		code = n & 0xff
+	}
+	return code, &ExitError{Code: code}
+}
+
+// builtinReturn returns from the current function or sourced script
+// with the given code, defaulting to the last exit code.
+func builtinReturn(sh *Shell, c *builtinCmd) (int, error) {
+	code := sh.lastExitCode
+	if len(c.args) > 1 {
+		n, err := strconv.Atoi(c.args[1])
+		if err != nil {
+			return 2, c.fatalf(2, "%s: numeric argument required", c.args[1])
+		}
+		code = n & 0xff
+	}
+	if sh.funcDepth == 0 {
+		return 2, c.fatalf(2, "can only `return' from a function or sourced script")
+	}
+	return code, &returnError{code: code}
+}
+
+// builtinExport marks the given variables for export, optionally assigning them.
+// Without argument or with -p, lists the exported variables.
+func builtinExport(sh *Shell, c *builtinCmd) (int, error) {
+	return declareVars(sh, c, func(v *variable) bool { return v.exported }, sh.exportVar)
+}
+
+// builtinReadonly marks the given variables as readonly, optionally assigning them.
+// Without argument or with -p, lists the readonly variables.
+func builtinReadonly(sh *Shell, c *builtinCmd) (int, error) {
+	return declareVars(sh, c, func(v *variable) bool { return v.readonly }, sh.readonlyVar)
+}
+
+// declareVars implements export and readonly, where has reports
+// if the variable has the attribute and set sets it.
+func declareVars(sh *Shell, c *builtinCmd, has func(*variable) bool, set func(name string)) (int, error) {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(sh.vars)) {
+			v := sh.vars[name]
+			if !has(v) {
+				continue
+			}
+			if v.set {
+				fmt.Fprintf(c.stdout, "%s %s=%s\n", c.args[0], name, quote(v.value))
+			} else {
+				fmt.Fprintf(c.stdout, "%s %s\n", c.args[0], name)
+			}
+		}
+		return 0, nil
+	}
+
+	exitCode := 0
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isName(name) {
+			c.errorf("`%s': not a valid identifier", arg)
+			exitCode = 1
+			continue
+		}
+		if hasValue {
+			if err := sh.setVar(name, value); err != nil {
+				c.errorf("%s", err)
+				exitCode = 1
+				continue
+			}
+		}
+		set(name)
+	}
+	return exitCode, nil
+}
+
+// shellOptions are the options supported by set, with their single letter flag if any.
+var shellOptions = []struct {
+	name string
+	flag rune
+}{
+	{"allexport", 'a'},
+	{"errexit", 'e'},
+	{"monitor", 'm'},
+	{"noclobber", 'C'},
+	{"noexec", 'n'},
+	{"noglob", 'f'},
+	{"nounset", 'u'},
+	{"pipefail", 0},
+	{"verbose", 'v'},
+	{"xtrace", 'x'},
+}
+
+// lookupOption returns the name of the option with the given name or flag.
+// Returns an empty string if not found.
+func lookupOption(name string, flag rune) string {
+	for _, opt := range shellOptions {
+		if (name != "" && opt.name == name) || (flag != 0 && opt.flag == flag) {
+			return opt.name
+		}
+	}
+	return ""
+}
+
+// optionFlags returns the single letter flags of the options set, i.e. $-.
+func (sh *Shell) optionFlags() string {
+	var flags strings.Builder
+	for _, opt := range shellOptions {
+		if opt.flag != 0 && sh.opts[opt.name] {
+			flags.WriteRune(opt.flag)
+		}
+	}
+	return flags.String()
+}
+
+// builtinSet sets the shell options and positional parameters.
+// Without argument, lists the shell variables.
+func builtinSet(sh *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if len(args) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(sh.vars)) {
+			if v := sh.vars[name]; v.set {
+				fmt.Fprintf(c.stdout, "%s=%s\n", name, quote(v.value))
+			}
+		}
+		return 0, nil
+	}
+
+	setArgs := false
+loop:
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "--":
+			args = args[1:]
+			setArgs = true
+			break loop
+		case arg == "-":
+			// `set -` ends the options, turning off xtrace and verbose.
+			args = args[1:]
+			sh.opts["xtrace"], sh.opts["verbose"] = false, false
+			break loop
+		case len(arg) < 2 || (arg[0] != '-' && arg[0] != '+'):
+			break loop
+		}
+		args = args[1:]
+		on := arg[0] == '-'
+		for _, flag := range arg[1:] {
+			if flag != 'o' {
+				name := lookupOption("", flag)
+				if name == "" {
+					c.errorf("%c%c: invalid option", arg[0], flag)
+					return 2, nil
+				}
+				sh.opts[name] = on
+				continue
+			}
+			// Without name, -o and +o list the options.
+			if len(args) == 0 {
+				for _, opt := range shellOptions {
+					switch {
+					case on && sh.opts[opt.name]:
+						fmt.Fprintf(c.stdout, "%-15s\ton\n", opt.name)
+					case on:
+						fmt.Fprintf(c.stdout, "%-15s\toff\n", opt.name)
+					case sh.opts[opt.name]:
+						fmt.Fprintf(c.stdout, "set -o %s\n", opt.name)
+					default:
+						fmt.Fprintf(c.stdout, "set +o %s\n", opt.name)
+					}
+				}
+				continue
+			}
+			name := lookupOption(args[0], 0)
+			if name == "" {
+				c.errorf("%s: invalid option name", args[0])
+				return 2, nil
+			}
+			args = args[1:]
+			sh.opts[name] = on
+		}
+	}
+	// Job control is enabled when possible, i.e. with a controlling terminal.
+	if sh.opts["monitor"] {
+		_ = sh.enableJobControl() // Best effort.
+	} else {
+		sh.disableJobControl()
+	}
+	if setArgs || len(args) > 0 {
+		sh.args = slices.Clone(args)
+	}
+	return 0, nil
+}
+
+// builtinShift shifts the positional parameters by the given count, defaulting to 1.
+func builtinShift(sh *Shell, c *builtinCmd) (int, error) {
+	n := 1
+	if len(c.args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(c.args[1]); err != nil || n < 0 {
+			return 2, c.fatalf(2, "%s: numeric argument required", c.args[1])
+		}
+	}
+	if n > len(sh.args) {
+		c.errorf("%d: shift count out of range", n)
+		return 1, nil
+	}
+	sh.args = sh.args[n:]
+	return 0, nil
+}
+
+// builtinTimes prints the user and system times of the shell and its children.
+func builtinTimes(_ *Shell, c *builtinCmd) (int, error) {
+	var self, children syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &self); err != nil {
+		c.errorf("%s", err)
+		return 1, nil
+	}
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children); err != nil {
+		c.errorf("%s", err)
+		return 1, nil
+	}
+	fmt.Fprintf(c.stdout, "%s %s\n", formatTimeval(self.Utime), formatTimeval(self.Stime))
+	fmt.Fprintf(c.stdout, "%s %s\n", formatTimeval(children.Utime), formatTimeval(children.Stime))
+	return 0, nil
+}
+
+// formatTimeval formats the given time as minutes and seconds, i.e. 0m0.001s.
+func formatTimeval(tv syscall.Timeval) string {
+	d := time.Duration(tv.Nano())
+	return fmt.Sprintf("%dm%.3fs", int(d.Minutes()), (d % time.Minute).Seconds())
+}
+
+// trapConditions are the conditions supported by trap.
+var trapConditions = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"EXIT", 0},
+	{"HUP", syscall.SIGHUP},
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL},
+	{"TRAP", syscall.SIGTRAP},
+	{"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS},
+	{"FPE", syscall.SIGFPE},
+	{"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1},
+	{"SEGV", syscall.SIGSEGV},
+	{"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE},
+	{"ALRM", syscall.SIGALRM},
+	{"TERM", syscall.SIGTERM},
+	{"CHLD", syscall.SIGCHLD},
+	{"CONT", syscall.SIGCONT},
+	{"STOP", syscall.SIGSTOP},
+	{"TSTP", syscall.SIGTSTP},
+	{"TTIN", syscall.SIGTTIN},
+	{"TTOU", syscall.SIGTTOU},
+	{"URG", syscall.SIGURG},
+	{"XCPU", syscall.SIGXCPU},
+	{"XFSZ", syscall.SIGXFSZ},
+	{"VTALRM", syscall.SIGVTALRM},
+	{"PROF", syscall.SIGPROF},
+	{"WINCH", syscall.SIGWINCH},
+	{"IO", syscall.SIGIO},
+	{"SYS", syscall.SIGSYS},
+}
+
+// lookupTrapCondition returns the name of the given trap condition,
+// either a signal name, with or without the SIG prefix, or a signal number.
+// Returns an empty string if not found.
+func lookupTrapCondition(cond string) string {
+	n, err := strconv.Atoi(cond)
+	cond = strings.TrimPrefix(strings.ToUpper(cond), "SIG")
+	for _, elem := range trapConditions {
+		if (err == nil && int(elem.sig) == n) || (err != nil && elem.name == cond) {
+			return elem.name
+		}
+	}
+	return ""
+}
+
+// builtinTrap sets the action to run when the shell receives the given conditions.
+// An action of "-" resets the conditions to their default, an empty one ignores them.
+// Without argument, lists the traps.
+func builtinTrap(sh *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, elem := range trapConditions {
+			if action, ok := sh.traps[elem.name]; ok {
+				fmt.Fprintf(c.stdout, "trap -- %s %s\n", quote(action), elem.name)
+			}
+		}
+		return 0, nil
+	}
+
+	action, conds := args[0], args[1:]
+	// When the first operand is a number or the only operand, all operands are reset.
+	if _, err := strconv.Atoi(action); err == nil || len(conds) == 0 {
+		action, conds = "-", args
+	}
+	exitCode := 0
+	for _, cond := range conds {
+		name := lookupTrapCondition(cond)
+		if name == "" {
+			c.errorf("%s: invalid signal specification", cond)
+			exitCode = 1
+			continue
+		}
+		sh.setTrap(name, action)
+	}
+	return exitCode, nil
+}
+
+// builtinUnset removes the given variables, or functions with -f.
+func builtinUnset(sh *Shell, c *builtinCmd) (int, error) {
+	funcs := false
+	args := c.args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		switch args[0] {
+		case "-v":
+			funcs = false
+		case "-f":
+			funcs = true
+		default:
+			c.errorf("%s: invalid option", args[0])
+			return 2, nil
+		}
+		args = args[1:]
+	}
+	exitCode := 0
+	for _, name := range args {
+		if !isName(name) {
+			c.errorf("`%s': not a valid identifier", name)
+			exitCode = 1
+			continue
+		}
+		if funcs {
+			delete(sh.funcs, name)
+			continue
+		}
+		if err := sh.unsetVar(name); err != nil {
+			c.errorf("%s", err)
+			exitCode = 1
+		}
+	}
+	return exitCode, nil
+}
@@ -1,10 +1,13 @@
 package executor
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
 )
 
 type CmdWrap struct {
@@ -46,6 +49,11 @@ func (c *CmdWrap) SetExtraFD(n int, file *os.File) {
 
 func (c *CmdWrap) GetProcessState() Exiter { return c.ProcessState }
 
+func (c *CmdWrap) Start() error {
+	setClosedStreams(c.Cmd)
+	return c.Cmd.Start()
+}
+
 type Exiter interface {
 	ExitCode() int
 }
@@ -70,3 +78,154 @@ type CmdIO interface {
 	Start() error
 	Wait() error
 }
+
+// nopCmd is a command doing nothing, used for commands without name,
+// i.e. only made of assignments and redirections.
+type nopCmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	exitCode int // Exit code of the last command substitution, if any.
+
+	extraFiles []*os.File
+}
+
+func (c *nopCmd) GetPath() string         { return "" }
+func (c *nopCmd) GetStdin() io.Reader     { return c.stdin }
+func (c *nopCmd) GetStdout() io.Writer    { return c.stdout }
+func (c *nopCmd) GetStderr() io.Writer    { return c.stderr }
+func (c *nopCmd) SetStdin(r io.Reader)    { c.stdin = r }
+func (c *nopCmd) SetStdout(w io.Writer)   { c.stdout = w }
+func (c *nopCmd) SetStderr(w io.Writer)   { c.stderr = w }
+func (c *nopCmd) GetProcessState() Exiter { return c }
+func (c *nopCmd) ExitCode() int           { return c.exitCode }
+func (c *nopCmd) Start() error            { return nil }
+func (c *nopCmd) Wait() error             { return nil }
+
+func (c *nopCmd) GetExtraFD(n int) *os.File {
+	if len(c.extraFiles) > n-3 {
+		return c.extraFiles[n-3]
+	}
+	return os.NewFile(uintptr(n), fmt.Sprintf("fd:%d", n))
+}
+
+func (c *nopCmd) SetExtraFD(n int, file *os.File) {
+	if len(c.extraFiles) <= n-3 {
+		extraFiles := make([]*os.File, n-3+1)
+		copy(extraFiles, c.extraFiles)
+		c.extraFiles = extraFiles
+	}
+	c.extraFiles[n-3] = file
+}
+
+// StdoutPipe returns a pipe reaching EOF right away as nothing is written.
+func (c *nopCmd) StdoutPipe() (io.ReadCloser, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("pipe: %w", err)
+	}
+	_ = w.Close() // Best effort.
+	return r, nil
+}
+
+// execCmd is an external command replacing the shell, i.e. `exec cmd`.
+// When replace is set, the shell process is replaced with syscall.Exec. Otherwise, i.e. in
+// pipelines or when the shell doesn't own the process, the command runs as a child process
+// and the shell exits with the command's exit code once it is done.
+type execCmd struct {
+	*CmdWrap
+	replace bool
+}
+
+func (c *execCmd) Start() error {
+	if !c.replace {
+		return c.CmdWrap.Start()
+	}
+	if c.Err != nil {
+		return c.Err
+	}
+	// Only files can become the descriptors of the process.
+	files := []*os.File{nil, nil, nil}
+	var closed [3]bool
+	for i, stream := range []any{c.Stdin, c.Stdout, c.Stderr} {
+		switch stream := stream.(type) {
+		case nil:
+		case closedStream:
+			closed[i] = true // Left nil, closed on exec.
+		case *os.File:
+			files[i] = stream
+		default:
+			return c.CmdWrap.Start()
+		}
+	}
+	for i, f := range files {
+		if f != nil || closed[i] {
+			continue
+		}
+		// As with exec.Cmd, nil standard streams are the null device.
+		devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", os.DevNull, err)
+		}
+		files[i] = devNull
+	}
+	if err := setProcessFiles(append(files, c.ExtraFiles...)); err != nil {
+		return err
+	}
+	if err := syscall.Exec(c.Path, c.Args, c.Env); err != nil {
+		return fmt.Errorf("exec %s: %w", c.Path, err)
+	}
+	return nil
+}
+
+func (c *execCmd) Wait() error {
+	if err := c.CmdWrap.Wait(); err != nil {
+		var e0 *exec.ExitError
+		if !errors.As(err, &e0) {
+			return err
+		}
+	}
+	return &ExitError{Code: exitStatus(c.ProcessState)}
+}
+
+// setProcessFiles makes the given files the descriptors of the process, by index,
+// for them to be inherited through exec. Nil files are closed on exec.
+func setProcessFiles(files []*os.File) error {
+	// Duplicate the files above the targets first, as their descriptors may be among them.
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = -1
+		if f == nil {
+			continue
+		}
+		fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_DUPFD_CLOEXEC, uintptr(len(files)))
+		if errno != 0 {
+			return fmt.Errorf("dup %d: %w", f.Fd(), errno)
+		}
+		fds[i] = int(fd)
+	}
+	for i, fd := range fds {
+		if fd == -1 {
+			syscall.CloseOnExec(i)
+			continue
+		}
+		if err := dup2(fd, i); err != nil {
+			return fmt.Errorf("dup2 %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// syncWriter serializes the writes to the underlying writer, shared between goroutines.
+// NOTE: It doesn't implement io.ReaderFrom so the copies are made of separate writes.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,30 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// commandSubstitution runs the given command in a subshell, reading the given stdin,
+// the one of the enclosing command, and returns its output without the trailing newlines. The exit code is kept as the one of commands
+// without name, i.e. `x=$(false)` fails.
+func (sh *Shell) commandSubstitution(cmdStr string, stdin io.Reader, stderr io.Writer) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	cmd := sh.subshellCommand(cmdStr)
+	cmd.Stdin = stdin
+	cmd.Stdout = buf
+	cmd.Stderr = stderr
+	setClosedStreams(cmd)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("command substitution %q: %w", cmdStr, err)
+		}
+	}
+	sh.substExitCode = exitStatus(cmd.ProcessState)
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
@@ -0,0 +1,6 @@
+package executor
+
+import "syscall"
+
+// dup2 duplicates the given descriptor to the given one, without close on exec.
+func dup2(oldfd, newfd int) error { return syscall.Dup3(oldfd, newfd, 0) }
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package executor
+
+import "syscall"
+
+// dup2 duplicates the given descriptor to the given one, without close on exec.
+func dup2(oldfd, newfd int) error { return syscall.Dup2(oldfd, newfd) }
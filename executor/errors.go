@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExitError is returned when the shell must stop with the given exit code,
+// i.e. the exit builtin or fatal errors.
+type ExitError struct {
+	Code int
+	Err  error // Reason reported on stderr, if any.
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// loopError is returned by break and continue to unwind the enclosing loops.
+type loopError struct {
+	n    int  // Number of enclosing loops to unwind.
+	cont bool // True for continue, resuming the n-th enclosing loop.
+}
+
+func (e *loopError) Error() string {
+	if e.cont {
+		return fmt.Sprintf("continue %d", e.n)
+	}
+	return fmt.Sprintf("break %d", e.n)
+}
+
+// returnError is returned by return to unwind the current function or sourced script.
+type returnError struct {
+	code int
+}
+
+func (e *returnError) Error() string { return fmt.Sprintf("return %d", e.code) }
+
+// redirectError is returned for failed redirections, already reported. It only fails the command,
+// with status 1, except for special builtins outside of pipelines, where the shell exits.
+type redirectError struct {
+	err error
+}
+
+func (e *redirectError) Error() string { return e.err.Error() }
+func (e *redirectError) Unwrap() error { return e.err }
+
+// isControlFlow returns true if the given error alters the control flow
+// rather than reporting a failure.
+func isControlFlow(err error) bool {
+	var (
+		exitErr   *ExitError
+		loopErr   *loopError
+		returnErr *returnError
+	)
+	return errors.As(err, &exitErr) || errors.As(err, &loopErr) || errors.As(err, &returnErr)
+}
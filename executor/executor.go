@@ -1,60 +1,353 @@
 package executor
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"slices"
+	"strconv"
+	"strings"
 
 	"go.creack.net/gosh2/ast"
 	"go.creack.net/gosh2/lexer"
 )
 
-func evaluateSimpleCommand(scmd *ast.SimpleCommand, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
-	var args []string
+func evaluateSimpleCommand(sh *Shell, scmd *ast.SimpleCommand, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
+	words := []ast.Word{scmd.Name}
 	if scmd.Suffix != nil {
-		args = scmd.Suffix.Words()
+		words = append(words, scmd.Suffix.Words()...)
 	}
-	cmd := exec.Command(scmd.Name, args...)
+	var assignments []ast.Assignment
+	if scmd.Prefix != nil {
+		assignments = scmd.Prefix.AssignmentWords()
+	}
+	sh.substExitCode = 0
+	fields, err := expandWords(sh, words, stdin, stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without command name, assignments apply to the shell itself.
+	if len(fields) == 0 {
+		if err := sh.assign(assignments, stdin, stderr); err != nil {
+			return nil, err
+		}
+		return &nopCmd{stdin: stdin, stderr: stderr, exitCode: sh.substExitCode}, nil
+	}
+
+	// `exec cmd` replaces the shell with the command.
+	isExec := fields[0] == "exec" && len(fields) > 1
+	if isExec {
+		fields = fields[1:]
+	} else if fn, ok := specialBuiltins[fields[0]]; ok {
+		// Assignments preceding special builtins persist in the shell.
+		if err := sh.assign(assignments, stdin, stderr); err != nil {
+			return nil, err
+		}
+		sh.xtrace(stderr, traceFields(fields))
+		return newBuiltinCmd(sh, fn, fields, stdin, stderr), nil
+	}
+
+	// Otherwise, assignments only apply to the command.
+	values := make([]string, len(assignments))
+	for i, a := range assignments {
+		value, err := expandWordString(sh, a.Value, stdin, stderr)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := sh.vars[a.Name]; ok && v.readonly {
+			return nil, &ExitError{Code: 1, Err: fmt.Errorf("%s: readonly variable", a.Name)}
+		}
+		values[i] = value
+		sh.xtrace(stderr, traceAssignment(a.Name, value))
+	}
+	if isExec {
+		sh.xtrace(stderr, "exec "+traceFields(fields))
+	} else {
+		sh.xtrace(stderr, traceFields(fields))
+	}
+	if body, ok := sh.funcs[fields[0]]; ok && !isExec {
+		fn := func(sh *Shell, c *builtinCmd) (int, error) {
+			return callFunction(sh, body, c.args[1:], c.stdin, c.stdout, c.stderr)
+		}
+		return newBuiltinCmd(sh, withAssignments(fn, assignments, values), fields, stdin, stderr), nil
+	}
+	if fn, ok := regularBuiltins[fields[0]]; ok && !isExec {
+		return newBuiltinCmd(sh, withAssignments(fn, assignments, values), fields, stdin, stderr), nil
+	}
+
+	// For external commands, they are exported to the environment.
+	env := sh.environ()
+	path, _ := sh.getVar("PATH")
+	for i, a := range assignments {
+		if a.Name == "PATH" {
+			path = values[i]
+		}
+		env = append(env, a.Name+"="+values[i])
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Path, cmd.Err = lookPath(fields[0], path)
 	cmd.Stdin = stdin
 	// NOTE: Stdout setup later.
 	cmd.Stderr = stderr
-	cmd.Env = os.Environ()
-	if scmd.Prefix != nil {
-		cmd.Env = append(cmd.Env, scmd.Prefix.AssignmentWords()...)
-	}
+	cmd.Env = env
+	cmd.ExtraFiles = sh.fds.extraFiles()
 
+	if isExec {
+		return &execCmd{CmdWrap: &CmdWrap{cmd}}, nil
+	}
 	return &CmdWrap{cmd}, nil
 }
 
-func evaluateCompoundCommand(compCmd *ast.CompoundCommandWrap, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
+// assign expands and sets the given assignments in the shell, tracing them on stderr with xtrace.
+func (sh *Shell) assign(assignments []ast.Assignment, stdin io.Reader, stderr io.Writer) error {
+	for _, a := range assignments {
+		value, err := expandWordString(sh, a.Value, stdin, stderr)
+		if err != nil {
+			return err
+		}
+		sh.xtrace(stderr, traceAssignment(a.Name, value))
+		if err := sh.setVar(a.Name, value); err != nil {
+			return &ExitError{Code: 1, Err: err}
+		}
+	}
+	return nil
+}
+
+// callFunction runs the given function body with the given positional parameters.
+// The redirections of the body apply for the duration of the call.
+func callFunction(sh *Shell, body *ast.CompoundCommandWrap, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	savedArgs := sh.args
+	sh.args = args
+	sh.funcDepth++
+	defer func() {
+		sh.args = savedArgs
+		sh.funcDepth--
+	}()
+
+	cmd, err := evaluateCompoundCommand(sh, body, stdin, stderr)
+	if err != nil {
+		return 1, err
+	}
+	cmd.SetStdout(stdout)
+	opened, err := setupCommandIO(sh, body, cmd)
+	if err != nil {
+		if _, err := redirectFailed(err, cmd, false); err != nil {
+			return 1, err
+		}
+		return 1, nil
+	}
+	defer closeFiles(opened)
+	if err := cmd.Start(); err != nil {
+		return 1, fmt.Errorf("start %q: %w", cmd.GetPath(), err)
+	}
+	err = cmd.Wait()
+	exitCode := exitStatus(cmd.GetProcessState())
+
+	var (
+		returnErr *returnError
+		exitErr   *exec.ExitError
+	)
+	switch {
+	case errors.As(err, &returnErr):
+		return returnErr.code, nil
+	case errors.As(err, &exitErr):
+		// Subshell bodies, the exit code is reported as is.
+		return exitCode, nil
+	}
+	return exitCode, err
+}
+
+func evaluateCompoundCommand(sh *Shell, compCmd *ast.CompoundCommandWrap, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
 	switch compCmd := compCmd.CompoundCommand.(type) {
 	case *ast.SubshellCommand:
-		exCmd := exec.Command(os.Args[0], "-sub", "-c", compCmd.Right.Dump())
+		exCmd := sh.subshellCommand(compCmd.Right.Dump())
 		exCmd.Stdin = stdin
 		exCmd.Stderr = stderr
-		exCmd.Env = os.Environ()
 		return &CmdWrap{exCmd}, nil
+	case *ast.BraceGroup:
+		return newShellCmd(sh, "{", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateCompoundList(sh, compCmd.Right, stdin, stdout, stderr)
+		}, stdin, stderr), nil
+	case *ast.IfClause:
+		return newShellCmd(sh, "if", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateIfClause(sh, compCmd, stdin, stdout, stderr)
+		}, stdin, stderr), nil
+	case *ast.ForClause:
+		return newShellCmd(sh, "for", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateForClause(sh, compCmd, stdin, stdout, stderr)
+		}, stdin, stderr), nil
+	case *ast.CaseClause:
+		return newShellCmd(sh, "case", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateCaseClause(sh, compCmd, stdin, stdout, stderr)
+		}, stdin, stderr), nil
+	case *ast.WhileClause:
+		return newShellCmd(sh, "while", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateLoop(sh, compCmd.Condition, compCmd.Body, false, stdin, stdout, stderr)
+		}, stdin, stderr), nil
+	case *ast.UntilClause:
+		return newShellCmd(sh, "until", func(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+			return evaluateLoop(sh, compCmd.Condition, compCmd.Body, true, stdin, stdout, stderr)
+		}, stdin, stderr), nil
 	default:
 		panic(fmt.Errorf("unsupported compound command type %T", compCmd))
 	}
 }
 
-func evaluateCommand(cmd ast.Command, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
+// newShellCmd returns a command running the given function in the current shell environment,
+// used for the compound commands other than subshells. Errors not altering the control flow
+// are reported on stderr as the command's failure.
+func newShellCmd(sh *Shell, name string, fn func(stdin io.Reader, stdout, stderr io.Writer) (int, error), stdin io.Reader, stderr io.Writer) *builtinCmd {
+	return newBuiltinCmd(sh, func(_ *Shell, c *builtinCmd) (int, error) {
+		exitCode, err := fn(c.stdin, c.stdout, c.stderr)
+		if err != nil && !isControlFlow(err) {
+			fmt.Fprintf(c.stderr, "%s: %s\n", sh.name, err)
+			return max(exitCode, 1), nil
+		}
+		return exitCode, err
+	}, []string{name}, stdin, stderr)
+}
+
+// evaluateIfClause runs the body of the first if or elif whose condition succeeds,
+// or the else part if none does. Without any branch run, the exit code is 0.
+func evaluateIfClause(sh *Shell, ifClause *ast.IfClause, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	condition, body, elsePart := ifClause.Condition, ifClause.Body, ifClause.Else
+	for {
+		if condition != nil {
+			exitCode, err := evaluateCondition(sh, condition, stdin, stdout, stderr)
+			if err != nil {
+				return exitCode, err
+			}
+		}
+		if condition == nil || sh.lastExitCode == 0 {
+			return evaluateCompoundList(sh, body, stdin, stdout, stderr)
+		}
+		if elsePart == nil {
+			return 0, nil
+		}
+		condition, body, elsePart = elsePart.Condition, elsePart.Body, elsePart.Else
+	}
+}
+
+// evaluateForClause runs the body for each field of the expanded words,
+// or each positional parameter without `in`. The exit code is the one of the last body run, 0 if none.
+func evaluateForClause(sh *Shell, forClause *ast.ForClause, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	values := slices.Clone(sh.args)
+	if forClause.In {
+		var err error
+		if values, err = expandWords(sh, forClause.Words, stdin, stderr); err != nil {
+			return 1, err
+		}
+	}
+
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	exitCode := 0
+	for _, value := range values {
+		if err := sh.setVar(forClause.Name, value); err != nil {
+			return 1, &ExitError{Code: 1, Err: err}
+		}
+		code, err := evaluateCompoundList(sh, forClause.Body, stdin, stdout, stderr)
+		exitCode = code
+		if err != nil {
+			if stop, err := handleLoopError(err); stop || err != nil {
+				return exitCode, err
+			}
+		}
+	}
+	return exitCode, nil
+}
+
+// evaluateCaseClause runs the body of the first item with a pattern matching the word.
+// Without any match, the exit code is 0.
+func evaluateCaseClause(sh *Shell, caseClause *ast.CaseClause, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	value, err := expandWordString(sh, caseClause.Word, stdin, stderr)
+	if err != nil {
+		return 1, err
+	}
+	for _, item := range caseClause.Items {
+		for _, word := range item.Patterns {
+			pattern, err := expandPattern(sh, word, stdin, stderr)
+			if err != nil {
+				return 1, err
+			}
+			if !matchPattern(pattern, value) {
+				continue
+			}
+			if item.Body == nil {
+				return 0, nil
+			}
+			return evaluateCompoundList(sh, item.Body, stdin, stdout, stderr)
+		}
+	}
+	return 0, nil
+}
+
+// evaluateLoop runs the body as long as the condition succeeds, or fails when until is set.
+// The exit code is the one of the last body run, 0 if none.
+func evaluateLoop(sh *Shell, condition, body *ast.CompoundList, until bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	exitCode := 0
+	for {
+		if _, err := evaluateCondition(sh, condition, stdin, stdout, stderr); err != nil {
+			if stop, err := handleLoopError(err); stop || err != nil {
+				return exitCode, err
+			}
+			continue
+		}
+		if (sh.lastExitCode == 0) == until {
+			return exitCode, nil
+		}
+		code, err := evaluateCompoundList(sh, body, stdin, stdout, stderr)
+		exitCode = code
+		if err != nil {
+			if stop, err := handleLoopError(err); stop || err != nil {
+				return exitCode, err
+			}
+		}
+	}
+}
+
+// handleLoopError processes the given error from within a loop.
+// Returns true if the loop must stop, and the error to propagate, if any,
+// i.e. break or continue targeting an enclosing loop.
+func handleLoopError(err error) (bool, error) {
+	var loopErr *loopError
+	if !errors.As(err, &loopErr) {
+		return true, err
+	}
+	if loopErr.n > 1 {
+		return true, &loopError{n: loopErr.n - 1, cont: loopErr.cont}
+	}
+	return !loopErr.cont, nil
+}
+
+func evaluateCommand(sh *Shell, cmd ast.Command, stdin io.Reader, stderr io.Writer) (CmdIO, error) {
 	switch c := cmd.(type) {
 	case *ast.SimpleCommand:
-		return evaluateSimpleCommand(c, stdin, stderr)
+		return evaluateSimpleCommand(sh, c, stdin, stderr)
+	case *ast.FunctionDefinition:
+		sh.funcs[c.Name] = c.Body
+		return &nopCmd{stdin: stdin, stderr: stderr}, nil
 	case *ast.CompoundCommandWrap:
-		return evaluateCompoundCommand(c, stdin, stderr)
+		return evaluateCompoundCommand(sh, c, stdin, stderr)
 	default:
 		panic(fmt.Errorf("unsupported command type %T", c))
 	}
 }
 
-func evaluatePipelineSequence(seq *ast.PipelineSequence, cmds *[]CmdIO, cmds2 *[]ast.Command, stdin io.Reader, stdout, stderr io.Writer) (CmdIO, error) {
+// evaluatePipelineSequence evaluates the commands of the given sequence, appending them to cmds
+// along with their node and the shell they run in, a copy of sh for each one when isolated,
+// i.e. in multi-command pipelines, where the commands run concurrently, like in subshells.
+func evaluatePipelineSequence(sh *Shell, seq *ast.PipelineSequence, isolated bool, cmds *[]CmdIO, cmds2 *[]ast.Command, shells *[]*Shell, stdin io.Reader, stdout, stderr io.Writer) (CmdIO, error) {
 	if seq.Left != nil {
-		nextExCmd, err := evaluatePipelineSequence(seq.Left, cmds, cmds2, stdin, stdout, stderr)
+		nextExCmd, err := evaluatePipelineSequence(sh, seq.Left, isolated, cmds, cmds2, shells, stdin, stdout, stderr)
 		if err != nil {
 			return nil, err
 		}
@@ -70,13 +363,17 @@ func evaluatePipelineSequence(seq *ast.PipelineSequence, cmds *[]CmdIO, cmds2 *[
 		// stderr = nextExCmd.GetStderr()
 	}
 
-	exCmd, err := evaluateCommand(seq.Right, stdin, stderr)
+	if isolated {
+		sh = sh.clone()
+	}
+	exCmd, err := evaluateCommand(sh, seq.Right, stdin, stderr)
 	if err != nil {
 		return nil, fmt.Errorf("evaluate command %q: %w", seq.Right.Dump(), err)
 	}
 	if exCmd != nil {
 		*cmds = append(*cmds, exCmd)
 		*cmds2 = append(*cmds2, seq.Right)
+		*shells = append(*shells, sh)
 	}
 	// if err := setupCommandIO(seq.Right, exCmd); err != nil {
 	// 	return nil, fmt.Errorf("setup cmd io %q: %w", exCmd.GetPath(), err)
@@ -85,10 +382,16 @@ func evaluatePipelineSequence(seq *ast.PipelineSequence, cmds *[]CmdIO, cmds2 *[
 	return exCmd, nil
 }
 
-func evaluatePipeline(pipeline *ast.Pipeline, stdin io.Reader, stdout, stderr io.Writer) (int, bool, error) {
+func evaluatePipeline(sh *Shell, pipeline *ast.Pipeline, stdin io.Reader, stdout, stderr io.Writer) (int, bool, error) {
+	// With noexec, commands are only read, except in interactive shells.
+	if sh.opts["noexec"] && !sh.interactive {
+		return 0, true, nil
+	}
+	stdin, stdout, stderr = sh.fds.streams(stdin, stdout, stderr)
 	var cmds []CmdIO
 	var cmds2 []ast.Command
-	lastCmd, err := evaluatePipelineSequence(pipeline.Right, &cmds, &cmds2, stdin, stdout, stderr)
+	var shells []*Shell
+	lastCmd, err := evaluatePipelineSequence(sh, pipeline.Right, pipeline.Right.Left != nil, &cmds, &cmds2, &shells, stdin, stdout, stderr)
 	if err != nil {
 		return -1, pipeline.Negated, fmt.Errorf("evaluate pipeline sequence %q: %w", pipeline.Right.Dump(), err)
 	}
@@ -116,62 +419,214 @@ func evaluatePipeline(pipeline *ast.Pipeline, stdin io.Reader, stdout, stderr io
 	lastCmd.SetStderr(stderr)
 
 	// Handle io redirections for the last command.
-	if err := setupCommandIO(cmds2[len(cmds2)-1], lastCmd); err != nil {
-		return 1, pipeline.Negated, err
+	// The files opened by the redirections are closed once the commands are done.
+	opened, err := setupCommandIO(shells[len(shells)-1], cmds2[len(cmds2)-1], lastCmd)
+	if err != nil {
+		if lastCmd, err = redirectFailed(err, lastCmd, len(cmds) == 1); err != nil {
+			return 1, pipeline.Negated, err
+		}
+		cmds[len(cmds)-1] = lastCmd
+	}
+	defer func() { closeFiles(opened) }()
+	// Outside of pipelines, which run like subshells, exec affects the shell itself.
+	if len(cmds) == 1 {
+		switch c := lastCmd.(type) {
+		case *builtinCmd:
+			if len(c.args) == 1 && c.args[0] == "exec" {
+				sh.execRedirect(cmds2[0], c, stdin, stdout, stderr)
+				opened = nil // The files now belong to the shell.
+			}
+		case *execCmd:
+			c.replace = sh.process
+		}
 	}
 
 	// For every other command in the pipeline, hook stdin to the previous command's stdout.
 	for i := len(cmds) - 1; i > 0; i-- {
 		stdin, _ := cmds[i-1].StdoutPipe()
 		cmds[i].SetStdin(stdin)
+		// The read ends of the pipes are closed along with the files, exec.Cmd only closes its own.
+		if f, ok := stdin.(*os.File); ok {
+			opened = append(opened, f)
+		}
 		cmds[i-1].SetStderr(stderr)
-		if err := setupCommandIO(cmds2[i-1], cmds[i-1]); err != nil {
-			return 1, pipeline.Negated, err
+		pipeW := cmds[i-1].GetStdout()
+		files, err := setupCommandIO(shells[i-1], cmds2[i-1], cmds[i-1])
+		if err != nil {
+			if cmds[i-1], err = redirectFailed(err, cmds[i-1], false); err != nil {
+				return 1, pipeline.Negated, err
+			}
+			// The command doesn't run, the next one reads EOF.
+			if f, ok := pipeW.(*os.File); ok {
+				_ = f.Close() // Best effort.
+			}
 		}
+		opened = append(opened, files...)
 	}
 
 	slices.Reverse(cmds)
 	// Start all commands in the pipeline.
+	// With job control, the processes get their own process group, in the foreground.
+	pgid := 0
 	for _, cmd := range cmds {
 		//fmt.Printf("[%d] %q\n", i, cmd.GetPath())
+		var proc *exec.Cmd
+		if sh.tty != nil {
+			proc = sh.setProcessGroup(cmd, pgid)
+		}
 		if err := cmd.Start(); err != nil {
-			return 1, false, fmt.Errorf("start %q: %w", cmd.GetPath(), err)
+			return 1, false, err // The errors name the command, e.g. not found.
+		}
+		if proc != nil && pgid == 0 {
+			pgid = proc.Process.Pid
 		}
 	}
-	// Wait on all commands in the pipeline. Keep track of the last exit code.
-	lastExitCode := 1
-	var lastErr error
-	const optPipefail = false // TODO: Actually implement pipefail.
-	for _, cmd := range cmds {
-		err := cmd.Wait()
-		if ps := cmd.GetProcessState(); ps != nil {
-			lastExitCode = ps.ExitCode()
+	// Wait on all commands in the pipeline, keeping track of their exit status in the pipeline order.
+	statuses := make([]int, len(cmds))
+	var ctrlErr error
+	wait := func() {
+		for i, cmd := range cmds {
+			err := cmd.Wait()
+			status := 1
+			if ps := cmd.GetProcessState(); ps != nil {
+				status = exitStatus(ps)
+			}
+			if isControlFlow(err) {
+				// Only single command pipelines run in the current shell environment,
+				// otherwise, like in a subshell, only the message of fatal errors remains.
+				var exitErr *ExitError
+				switch {
+				case len(cmds) == 1:
+					ctrlErr = err
+				case errors.As(err, &exitErr) && exitErr.Err != nil:
+					fmt.Fprintf(stderr, "gosh2: %s\n", exitErr.Err)
+				}
+				err = nil
+			}
+			// Commands failing without exit status, i.e. on I/O errors, are failures.
+			if err != nil && status == 0 {
+				status = 1
+			}
+			statuses[len(cmds)-1-i] = status // The commands are started in reverse order.
 		}
-		if err != nil && optPipefail {
-			return lastExitCode, pipeline.Negated, fmt.Errorf("wait %q: %w", cmd.GetPath(), err)
+	}
+	if pgid == 0 {
+		wait()
+	} else {
+		// The pipeline can be stopped, i.e. ^Z, in which case it becomes a job left to complete.
+		j := &job{pgid: pgid, pid: pgid, text: pipeline.Dump(), done: make(chan struct{})}
+		pipefail := sh.opts["pipefail"]
+		files := opened
+		opened = nil // Closed by the job once done, as it can be stopped.
+		go func() {
+			defer close(j.done)
+			defer closeFiles(files)
+			wait()
+			j.exitCode = pipelineStatus(statuses, pipefail)
+		}()
+		if sh.waitForeground(j) {
+			sh.lastExitCode = sh.stopJob(j, stderr)
+			return sh.lastExitCode, false, nil
 		}
-		lastErr = err
+	}
+	// PIPESTATUS holds the space separated statuses of the commands.
+	strs := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		strs = append(strs, strconv.Itoa(status))
+	}
+	_ = sh.setVar("PIPESTATUS", strings.Join(strs, " ")) // Best effort, only fails if readonly.
+
+	exitCode := pipelineStatus(statuses, sh.opts["pipefail"])
+	if ctrlErr != nil {
+		sh.lastExitCode = exitCode
+		return exitCode, exitCode == 0, ctrlErr
 	}
 
-	success := lastExitCode == 0 && lastErr == nil
+	success := exitCode == 0
 	if pipeline.Negated {
 		success = !success
+		exitCode = 0
+		if !success {
+			exitCode = 1
+		}
+	}
+	sh.lastExitCode = exitCode
+	if err := sh.runTraps(stdin, stdout, stderr); err != nil {
+		return exitCode, success, err
+	}
+	if !success && !pipeline.Negated && sh.errexit(cmds2[len(cmds2)-1]) {
+		return exitCode, success, &ExitError{Code: exitCode}
+	}
+	return exitCode, success, nil
+}
+
+// redirectFailed returns the command to run in place of the given one, which redirections failed
+// with the given error: doing nothing, with status 1. Special builtins run alone, i.e. not in a pipeline,
+// exit the shell instead, and the other errors are returned as is, e.g. expansion errors.
+func redirectFailed(err error, cmd CmdIO, alone bool) (CmdIO, error) {
+	var redirectErr *redirectError
+	if !errors.As(err, &redirectErr) {
+		return nil, err
+	}
+	if c, ok := cmd.(*builtinCmd); ok && alone && specialBuiltins[c.args[0]] != nil {
+		return nil, &ExitError{Code: 1}
+	}
+	return &nopCmd{stdin: cmd.GetStdin(), stderr: cmd.GetStderr(), exitCode: 1}, nil
+}
+
+// errexit returns true if the failure of the given command, ending a pipeline, must exit the shell,
+// i.e. with errexit, outside of conditions. Compound commands other than subshells only fail
+// on failures where errexit was ignored, as the others already exited.
+func (sh *Shell) errexit(cmd ast.Command) bool {
+	if !sh.opts["errexit"] || sh.noErrexit > 0 {
+		return false
 	}
-	return lastExitCode, success, nil
+	if c, ok := cmd.(*ast.CompoundCommandWrap); ok {
+		_, ok := c.CompoundCommand.(*ast.SubshellCommand)
+		return ok
+	}
+	return true
+}
+
+// evaluateCondition runs the given condition of if, while or until, where errexit is ignored.
+func evaluateCondition(sh *Shell, condition *ast.CompoundList, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	sh.noErrexit++
+	defer func() { sh.noErrexit-- }()
+	return evaluateCompoundList(sh, condition, stdin, stdout, stderr)
+}
+
+// pipelineStatus returns the exit status of a pipeline given the status of its commands,
+// in the pipeline order: the one of the last command, or with pipefail, the last non-zero one.
+func pipelineStatus(statuses []int, pipefail bool) int {
+	if pipefail {
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i] != 0 {
+				return statuses[i]
+			}
+		}
+	}
+	return statuses[len(statuses)-1]
 }
 
-func evaluateAndOr(andOr *ast.AndOr, stdin io.Reader, stdout, stderr io.Writer) (int, bool, error) {
+func evaluateAndOr(sh *Shell, andOr *ast.AndOr, stdin io.Reader, stdout, stderr io.Writer) (int, bool, error) {
 	// If there is no left side, we only have a pipeline.
 	if andOr.Left == nil {
-		return evaluatePipeline(andOr.Right, stdin, stdout, stderr)
+		return evaluatePipeline(sh, andOr.Right, stdin, stdout, stderr)
 	}
 	if andOr.Separator == 0 { // Should never happen.
 		panic("missing andor separator")
 	}
-	// Otherwise, recurse into the left side.
-	exitCode, success, err := evaluateAndOr(andOr.Left, stdin, stdout, stderr)
+	// Otherwise, recurse into the left side, where errexit is ignored.
+	sh.noErrexit++
+	exitCode, success, err := evaluateAndOr(sh, andOr.Left, stdin, stdout, stderr)
+	sh.noErrexit--
+	if isControlFlow(err) {
+		return exitCode, success, err
+	}
+	// Other errors only fail the left side, reported as the shell.
 	if err != nil {
-		fmt.Fprintf(stderr, "eval andor: %s\n", err)
+		fmt.Fprintf(stderr, "gosh2: %s\n", err)
+		sh.lastExitCode = exitCode
 	}
 	// If we are in a AND and have a failure, stop here.
 	if andOr.Separator == lexer.TokAndIf && !success {
@@ -182,33 +637,104 @@ func evaluateAndOr(andOr *ast.AndOr, stdin io.Reader, stdout, stderr io.Writer)
 		return exitCode, success, nil
 	}
 	// Otherwise, execute the right side.
-	return evaluatePipeline(andOr.Right, stdin, stdout, stderr)
+	return evaluatePipeline(sh, andOr.Right, stdin, stdout, stderr)
 }
 
-func evaluateList(list *ast.List, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+// evaluateList executes the given list. When async is set, the last and-or list,
+// followed by `&`, is started in the background.
+func evaluateList(sh *Shell, list *ast.List, async bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
 	if list.Left != nil {
-		exitCode, err := evaluateList(list.Left, stdin, stdout, stderr)
+		// The separator terminates the left side.
+		exitCode, err := evaluateList(sh, list.Left, list.Separator == lexer.TokAmpersand, stdin, stdout, stderr)
 		if err != nil {
 			return exitCode, err
 		}
 	}
-	// TODO: Handle separator (job control).
-	if list.Separator == lexer.TokAmpersand {
-		panic("job control not implemented")
+	if list.Right == nil {
+		return -1, nil
 	}
-	if list.Right != nil {
-		exitCode, _, err := evaluateAndOr(list.Right, stdin, stdout, stderr)
-		return exitCode, err
+	if async {
+		return evaluateAsync(sh, list.Right, stdin, stdout, stderr)
 	}
-	return -1, nil
+	exitCode, _, err := evaluateAndOr(sh, list.Right, stdin, stdout, stderr)
+	return exitCode, err
+}
+
+func evaluateCompoundList(sh *Shell, cList *ast.CompoundList, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return evaluateTerm(sh, cList.Term, cList.Separator == lexer.TokAmpersand, stdin, stdout, stderr)
 }
 
-func Evaluate(completeCmd ast.CompleteCommand, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
-	// TODO: Handle separator (job control).
-	if completeCmd.Separator == lexer.TokAmpersand {
-		panic("job control not implemented")
+// evaluateTerm executes the given term. When async is set, the last and-or list,
+// followed by `&`, is started in the background.
+func evaluateTerm(sh *Shell, term *ast.Term, async bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if term.Left != nil {
+		// The separator terminates the left side.
+		exitCode, err := evaluateTerm(sh, term.Left, term.Separator == lexer.TokAmpersand, stdin, stdout, stderr)
+		if err != nil {
+			return exitCode, err
+		}
+	}
+	if async {
+		return evaluateAsync(sh, term.Right, stdin, stdout, stderr)
+	}
+	exitCode, _, err := evaluateAndOr(sh, term.Right, stdin, stdout, stderr)
+	return exitCode, err
+}
+
+// Parser is the interface of the parser used to evaluate strings, i.e. eval and `.`.
+type Parser interface {
+	NextCompleteCommand() *ast.CompleteCommand
+}
+
+// nextCompleteCommand returns the next command from the given parser,
+// reporting syntax errors as errors.
+func nextCompleteCommand(p Parser) (cmd *ast.CompleteCommand, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("syntax error: %v", r)
+		}
+	}()
+	return p.NextCompleteCommand(), nil
+}
+
+// evalInput parses and executes the given input within the shell.
+// Unlike Evaluate, errors are returned without being reported.
+func (sh *Shell) evalInput(input, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	p := sh.newParser(input)
+	exitCode := 0
+	for {
+		cmd, err := nextCompleteCommand(p)
+		if err != nil {
+			return 2, &ExitError{Code: 2, Err: err}
+		}
+		if cmd == nil {
+			return exitCode, nil
+		}
+		if exitCode, err = evaluateCompleteCommand(sh, *cmd, stdin, stdout, stderr); err != nil {
+			return exitCode, err
+		}
+	}
+}
+
+func evaluateCompleteCommand(sh *Shell, completeCmd ast.CompleteCommand, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return evaluateList(sh, completeCmd.List, completeCmd.Separator == lexer.TokAmpersand, stdin, stdout, stderr)
+}
+
+// Evaluate executes the given complete command within the given shell.
+// Errors are reported on stderr.
+func Evaluate(sh *Shell, completeCmd ast.CompleteCommand, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	// Unless embedded with its own output, the shell is the process, which exec replaces.
+	sh.process = stdout == io.Writer(os.Stdout)
+	stdout, stderr = sh.syncOutput(stdout), sh.syncOutput(stderr)
+	sh.notifyJobs(stderr)
+	exitCode, err := evaluateCompleteCommand(sh, completeCmd, stdin, stdout, stderr)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Err != nil {
+			fmt.Fprintf(stderr, "gosh2: %s\n", exitErr.Err)
+		}
+		return exitErr.Code, err
 	}
-	exitCode, err := evaluateList(completeCmd.List, stdin, stdout, stderr)
 	if err != nil {
 		fmt.Fprintf(stderr, "gosh2: %s\n", err)
 	}
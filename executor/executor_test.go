@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/creack/pty"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -41,15 +45,15 @@ func setupEnv(t *testing.T) {
 	for _, name := range []string{
 		"myecho",
 		"mygetenv",
-		"exit", // TODO: Remvoe in favor of proper builtin.
 		// TODO: Implement rm, ls, cat, cat -e, grep.
 	} {
 		require.NoError(t, os.WriteFile("bin/"+name, src, 0755), "failed to write file %q", name)
 	}
 
-	require.NoError(t, os.Setenv("GOSH2_TEST", "1"), "failed to set env GOSH2_TEST")
+	// NOTE: t.Setenv restores the variables once done, PATH would otherwise keep growing.
+	t.Setenv("GOSH2_TEST", "1")
 	// TODO: Remove the parent's PATH once all binaries are implemented.
-	require.NoError(t, os.Setenv("PATH", tmpDir+"/bin:"+os.Getenv("PATH")), "failed to set env PATH")
+	t.Setenv("PATH", tmpDir+"/bin:"+os.Getenv("PATH"))
 }
 
 func TestMain(m *testing.M) {
@@ -73,14 +77,16 @@ func TestMain(m *testing.M) {
 		}
 		fmt.Printf("%s\n", v)
 		return
-	case "exit": // TODO: Remove in favor of proper builtin.
-		n, _ := strconv.Atoi(os.Args[1])
-		os.Exit(n)
 	}
 
 	if parser.RunSubshell(os.Args, os.Exit, os.Stdin, os.Stdout, os.Stderr) {
 		return
 	}
+	// -i runs an interactive shell reading the commands from stdin, used to test job control.
+	if len(os.Args) == 2 && os.Args[1] == "-i" {
+		exitCode, _ := parser.Run(os.Stdin, os.Stdin, os.Stdout, os.Stderr)
+		os.Exit(exitCode)
+	}
 
 	// Close extra file descriptors opened by wrappers like `air` or `reflex`.
 	// Needed for consistent error handling.
@@ -118,6 +124,9 @@ func TestExecutor(t *testing.T) {
 		{name: "fd right redirect pipe", input: "echo hello >&2 | cat -e", stderr: "hello\n", skip: []string{"zsh"}},
 		{name: "andors success", input: "ls a && echo why && echo ok1 || echo ko2 && echo ok2; cat foo; echo -1-", stdout: "a\nwhy\nok1\nok2\nfoocontent\n-1-\n"},
 		{name: "andors failure", input: "ls /foo/bar/not/exists && echo why && echo ok1 || echo ko2 && echo ok2; cat foo; echo -1-", stdout: "ko2\nok2\nfoocontent\n-1-\n", exitCode: 0},
+		{name: "syntax error", input: "fi", stderr: "^gosh2: syntax error: expected token \\[EOF NEWLINE\\] but got WORD \\(WORD\\[1:2\\]: \"fi\"\\)\n$", exitCode: 2, wantErr: true, skip: []string{"sh", "bash"}},
+		{name: "syntax error missing word", input: "echo a >", stderr: "^gosh2: syntax error: expected token \\[WORD\\] but got EOF \\(EOF\\)\n$", exitCode: 2, wantErr: true, skip: []string{"sh", "bash"}},
+		{name: "andors error", input: "gosh2nosuch || echo ko", stdout: "ko\n", stderr: "^[a-z0-9]+:( line)?( 1:)? gosh2nosuch: (command )?not found\n$"},
 		// TODO: Add full set of tests for and/or, semicolumn, pipes asserting final exitcode.
 		{name: "simple pipe", input: "ls a aa | cat -e", stdout: "a$\naa$\n"},
 		{name: "multi pipe redirect", input: "< foo cat | cat -e | cat -e > bar; cat bar", stdout: "foocontent$$\n"},
@@ -192,12 +201,11 @@ func TestLeftRedirect(t *testing.T) {
 		{name: "suffix single quote space", input: "cat < 'foo'", stdout: "foocontent\n"},
 		{name: "suffix double quote no space", input: "cat<\"foo\"", stdout: "foocontent\n"},
 		{name: "suffix double quote space", input: "cat < \"foo\"", stdout: "foocontent\n"},
-		// TODO: Handle this.
-		// {name: "suffix mixed quote", input: "cat<\"f\"o'o'", stdout: "foocontent\n"},
-		// {name: "suffix subst no space", input: "<$(echo foo) cat", stdout: "foocontent\n"},
-		// {name: "suffix subst space", input: " < $(echo foo) cat", stdout: "foocontent\n"},
-		// {name: "suffix backtick no space", input: "<`echo foo` cat", stdout: "foocontent\n"},
-		// {name: "suffix backtick space", input: " < `echo foo` cat", stdout: "foocontent\n"},
+		{name: "suffix mixed quote", input: "cat<\"f\"o'o'", stdout: "foocontent\n"},
+		{name: "suffix subst no space", input: "<$(echo foo) cat", stdout: "foocontent\n"},
+		{name: "suffix subst space", input: " < $(echo foo) cat", stdout: "foocontent\n"},
+		{name: "suffix backtick no space", input: "<`echo foo` cat", stdout: "foocontent\n"},
+		{name: "suffix backtick space", input: " < `echo foo` cat", stdout: "foocontent\n"},
 
 		{name: "prefix no space", input: "<foo cat", stdout: "foocontent\n"},
 		{name: "prefix right space", input: "< foo cat", stdout: "foocontent\n"},
@@ -218,12 +226,645 @@ func TestLeftRedirect(t *testing.T) {
 		{name: "prefix single quote space", input: " < 'foo' cat", stdout: "foocontent\n"},
 		{name: "prefix double quote no space", input: "<\"foo\" cat", stdout: "foocontent\n"},
 		{name: "prefix double quote space", input: " < \"foo\" cat", stdout: "foocontent\n"},
-		// TODO: Handle this.
-		// {name: "prefix mixed quote", input: "<\"f\"o'o' cat", stdout: "foocontent\n"},
-		// {name: "prefix subst no space", input: "<$(echo foo) cat", stdout: "foocontent\n"},
-		// {name: "prefix subst space", input: " < $(echo foo) cat", stdout: "foocontent\n"},
-		// {name: "prefix backtick no space", input: "<`echo foo` cat", stdout: "foocontent\n"},
-		// {name: "prefix backtick space", input: " < `echo foo` cat", stdout: "foocontent\n"},
+		{name: "prefix mixed quote", input: "<\"f\"o'o' cat", stdout: "foocontent\n"},
+		{name: "prefix subst no space", input: "<$(echo foo) cat", stdout: "foocontent\n"},
+		{name: "prefix subst space", input: " < $(echo foo) cat", stdout: "foocontent\n"},
+		{name: "prefix backtick no space", input: "<`echo foo` cat", stdout: "foocontent\n"},
+		{name: "prefix backtick space", input: " < `echo foo` cat", stdout: "foocontent\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestParameterExpansion(t *testing.T) {
+	tests := []testCase{
+		{name: "env var", input: "echo $GOSH2_TEST", stdout: "1\n"},
+		{name: "assignment", input: "a=b; echo $a", stdout: "b\n"},
+		{name: "assignment multi", input: "a=b c=d; echo $a$c", stdout: "bd\n"},
+		{name: "assignment expansion", input: "a=b; c=$a$a; echo $c", stdout: "bb\n"},
+		{name: "double quote", input: `a=b; echo "x $a y"`, stdout: "x b y\n"},
+		{name: "single quote", input: `a=b; echo '$a'`, stdout: "$a\n"},
+		{name: "escaped dollar", input: `a=b; myecho \$a "\$a" "\\$a"`, stdout: "Args: 3\n$a $a \\b\n"},
+		{name: "neighbors", input: "a=b; echo x$a'y'\"$a\"", stdout: "xbyb\n"},
+		{name: "unset", input: "echo $gosh2unset x", stdout: "x\n"},
+		{name: "unset quoted", input: `myecho "$gosh2unset" x`, stdout: "Args: 2\n x\n"},
+		{name: "lone dollar", input: "echo $ a$", stdout: "$ a$\n"},
+		{name: "exit code", input: "ls /foo/bar/not/exists 2> /dev/null; echo $?; echo $?", stdout: "^[12]\n0\n$"},
+		{name: "exit code negated", input: "! ls /foo/bar/not/exists 2> /dev/null; echo $?", stdout: "0\n"},
+		{name: "positional empty", input: `myecho "$@" $# $1`, stdout: "Args: 1\n0\n"},
+		{name: "command name", input: "a=myecho; $a hello", stdout: "Args: 1\nhello\n"},
+		{name: "redirect target", input: "a=bar; echo hello > $a; cat bar", stdout: "hello\n"},
+		{name: "prefix", input: "a=bar; fooa=$a mygetenv fooa", stdout: "bar\n"},
+		{name: "subshell", input: "(a=b; echo $a)", stdout: "b\n"},
+		{name: "subshell pid", input: "echo $$ >bar; (echo $$) >>bar; echo $(echo $$) >>bar; echo `echo $$` >>bar; uniq bar | wc -l | tr -d ' '", stdout: "1\n"},
+		{name: "subshell exit code", input: "false; (echo $?); false; echo $(echo $?); (exit 3); (echo $?) | cat", stdout: "1\n1\n3\n"},
+
+		{name: "braces", input: "a=b; echo ${a}c", stdout: "bc\n"},
+		{name: "braces quoted", input: `a=b; echo "${a}c"`, stdout: "bc\n"},
+		{name: "default", input: "a=; echo ${a:-x} ${a-y} ${gosh2unset-z}.", stdout: "x z.\n"},
+		{name: "default quoted", input: `myecho "${gosh2unset:-a  b}"`, stdout: "Args: 1\na  b\n"},
+		{name: "default nested", input: "b=c; echo ${gosh2unset:-${b}d}", stdout: "cd\n"},
+		{name: "default not evaluated", input: "a=b; echo ${a:-${c=d}}; echo $c.", stdout: "b\n.\n"},
+		{name: "assign default", input: "a=; echo ${a:=x} ${b=y}; echo $a $b", stdout: "x y\nx y\n"},
+		{name: "alternative", input: "a=; b=c; echo ${a:+x}.${a+y}.${b:+z}.${gosh2unset+w}.", stdout: ".y.z..\n"},
+		{name: "error", input: "echo ${gosh2unset:?custom message}; echo unreachable", stderr: "^[a-z0-9]+:( line 1:)? gosh2unset: custom message\n$", exitCode: 1, wantErr: true, skip: []string{"sh", "bash -c", "bash --posix -c"}},
+		{name: "error not triggered", input: "a=b; echo ${a:?msg}", stdout: "b\n"},
+		{name: "length", input: "a=hello; echo ${#a} ${#gosh2unset}", stdout: "5 0\n"},
+		{name: "trim prefix", input: "a=/a/b/c; echo ${a#*/} ${a##*/}", stdout: "a/b/c c\n"},
+		{name: "trim suffix", input: "a=f.tar.gz; echo ${a%.*} ${a%%.*}", stdout: "f.tar f\n"},
+		{name: "trim bracket", input: "a=abc; echo ${a%[bc]} ${a%%[!a]*} ${a#[[:alpha:]]}", stdout: "ab a bc\n"},
+		{name: "trim quoted pattern", input: `a='a*b'; echo ${a#"a*"} ${a#a\*} "${a#a*}"`, stdout: "b b *b\n"},
+		{name: "trim no match", input: "a=abc; echo ${a#x} ${a%x}", stdout: "abc abc\n"},
+		{name: "bad substitution", input: "echo a\necho ${}\necho b", stdout: "a\n", stderr: "^[a-z0-9]+: .*[Bb]ad substitution.*\n$", exitCode: 2, wantErr: true, skip: []string{"bash"}},
+		{name: "unclosed", input: "echo ${a", stderr: "^[a-z0-9]+: .*(unclosed|[Mm]issing|matching).*\n(.*\n)?$", exitCode: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestVariables(t *testing.T) {
+	tests := []testCase{
+		{name: "exported from env", input: "GOSH2_TEST=2; mygetenv GOSH2_TEST", stdout: "2\n"},
+		{name: "not exported", input: "fooa=bar; mygetenv fooa", exitCode: 1, wantErr: true},
+		{name: "prefix", input: "fooa=bar mygetenv fooa", stdout: "bar\n"},
+		{name: "prefix not persisted", input: "fooa=bar; fooa=baz mygetenv fooa; echo $fooa", stdout: "baz\nbar\n"},
+		{name: "prefix not set", input: "fooa=baz mygetenv fooa; echo $fooa.", stdout: "baz\n.\n"},
+		{name: "subshell local", input: "a=b; (echo $a)", stdout: "b\n"},
+		{name: "subshell local quoted", input: `a="it's a  'test'"; (echo "$a")`, stdout: "it's a  'test'\n"},
+		{name: "subshell not leaking", input: "a=b; (a=c; d=e); echo $a $d.", stdout: "b .\n"},
+		{name: "subshell exported", input: "GOSH2_TEST=2; (mygetenv GOSH2_TEST)", stdout: "2\n"},
+		{name: "subshell local not exported", input: "fooa=bar; (mygetenv fooa)", exitCode: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestSpecialBuiltins(t *testing.T) {
+	tests := []testCase{
+		{name: "colon", input: ": a b; echo $?", stdout: "0\n"},
+		{name: "colon assignment", input: "a=b :; echo $a", stdout: "b\n", skip: []string{"bash", "zsh"}}, // Not persisted in bash non-posix mode.
+		{name: "exit", input: "exit 3; echo unreachable", exitCode: 3, wantErr: true},
+		{name: "exit last code", input: "ls /foo/bar/not/exists 2> /dev/null; exit", stdout: "", exitCode: 2, wantErr: true, skip: []string{"sh", "zsh"}},
+		{name: "exit subshell", input: "(exit 4); echo $?", stdout: "4\n"},
+		{name: "exit pipeline", input: "exit 4 | cat; echo ok", stdout: "ok\n"},
+		{name: "eval", input: `a='b=c; echo $b'; eval $a; echo $b`, stdout: "c\nc\n"},
+		{name: "eval exit code", input: "eval false; echo $?", stdout: "1\n"},
+		{name: "dot", input: "echo 'a=b; echo in' > bar; . ./bar; echo $a", stdout: "in\nb\n"},
+		{name: "dot return", input: "echo 'return 4; echo unreachable' > bar; . ./bar; echo $?", stdout: "4\n"},
+		{name: "dot not found", input: ". ./gosh2notfound; echo unreachable", stderr: "^.*gosh2notfound.*\n$", exitCode: 1, wantErr: true, skip: []string{"sh", "bash", "zsh"}},
+		{name: "return outside function", input: "return; echo unreachable", stderr: "^[a-z0-9]+:( line 1:)? return: can only `return' from a function or sourced script\n$", exitCode: 2, wantErr: true, skip: []string{"sh", "bash", "zsh"}},
+		{name: "break outside loop", input: "break; echo $?", stdout: "0\n", skip: []string{"zsh"}},
+		{name: "exec", input: "exec echo hello; echo unreachable", stdout: "hello\n"},
+		{name: "exec exit code", input: "exec ls /foo/bar/not/exists 2> /dev/null; echo unreachable", exitCode: 2, wantErr: true},
+		{name: "export", input: "fooa=bar; export fooa; mygetenv fooa", stdout: "bar\n"},
+		{name: "export assign", input: "export fooa=bar; mygetenv fooa", stdout: "bar\n"},
+		{name: "export list", input: "export gosh2a=b; export -p | grep gosh2a", stdout: "^export gosh2a=[\"']b[\"']\n$", skip: []string{"bash", "zsh"}},
+		{name: "export invalid", input: "export 1a=b; echo $?", stdout: "1\n", stderr: "^.*1a=b.*not a valid identifier\n$", skip: []string{"sh"}},
+		{name: "readonly", input: "readonly a=b; a=c; echo unreachable", stderr: "^.*a: readonly variable\n$", exitCode: 1, wantErr: true, skip: []string{"sh", "bash -c", "bash --posix -c", "zsh"}},
+		{name: "readonly unset", input: "readonly a=b; unset a; echo $? $a", stdout: "1 b\n", skip: []string{"sh"}},
+		{name: "unset", input: "a=b; unset a; echo ${a-unset}", stdout: "unset\n"},
+		{name: "unset exported", input: "unset GOSH2_TEST; mygetenv GOSH2_TEST", exitCode: 1, wantErr: true},
+		{name: "set positional", input: `set -- a "b c"; myecho "$@"; echo $#`, stdout: "Args: 2\na b c\n2\n"},
+		{name: "set clear positional", input: "set -- a b; set --; echo $#", stdout: "0\n"},
+		{name: "set list", input: "a='x y'; set | grep '^a='", stdout: "a='x y'\n", skip: []string{"zsh"}},
+		{name: "set invalid option", input: "set -Z; echo $?", stdout: "2\n", stderr: "^.*Z.*\n$", skip: []string{"sh", "bash", "zsh"}},
+		{name: "set allexport", input: "set -a; fooa=bar; mygetenv fooa", stdout: "bar\n"},
+		{name: "shift", input: "set -- a b c; shift; echo $@; shift 2; echo $#", stdout: "b c\n0\n"},
+		{name: "shift out of range", input: "set -- a; shift 2; echo $? $1", stdout: "1 a\n", skip: []string{"sh", "zsh"}},
+		{name: "times", input: "times | wc -l", stdout: "^ *2\n$"},
+		{name: "trap list", input: "trap 'echo hi' EXIT; trap; trap - EXIT; trap", stdout: "trap -- 'echo hi' EXIT\n", skip: []string{"zsh"}},
+		{name: "trap reset number", input: "trap 'echo hi' INT; trap 2; trap", stdout: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestRegularBuiltins(t *testing.T) {
+	tests := []testCase{
+		{name: "true false", input: "true; echo $?; false; echo $?", stdout: "0\n1\n"},
+		{name: "echo", input: "echo a  b; echo", stdout: "a b\n\n"},
+		{name: "echo no newline", input: "echo -n a; echo b", stdout: "ab\n", skip: []string{"zsh"}},
+		{name: "echo pipe", input: "echo hello | cat -e", stdout: "hello$\n"},
+		{name: "echo redirect", input: "echo hello > bar 2>&1; cat bar", stdout: "hello\n"},
+
+		{name: "cd", input: "mkdir -p d1/d2; cd d1/d2; pwd | sed 's|.*/||'; cd ..; ls", stdout: "d2\nd2\n"},
+		{name: "cd home", input: "mkdir d1; HOME=$PWD/d1; cd; ls -a", stdout: ".\n..\n"},
+		{name: "cd oldpwd", input: "mkdir d1; cd d1; cd - > ../bar; ls d1 && sed 's|.*/||' bar", stdout: "^gosh2-executor[0-9]+\n$"},
+		{name: "cd not found", input: "cd gosh2notfound; echo $?", stdout: "1\n", stderr: "^.*gosh2notfound: [Nn]o such file or directory\n$", skip: []string{"sh", "zsh"}},
+		{name: "cd cdpath", input: "mkdir -p d1/d2; CDPATH=$PWD/d1; cd d2 > bar; sed 's|.*/d1/|/|' ../../bar; ls ..", stdout: "/d2\nd2\n"},
+		{name: "cd logical", input: "mkdir -p d1/d2; ln -s d1/d2 lnk; cd lnk; cd ..; ls -d d1", stdout: "d1\n"},
+		{name: "pwd logical", input: "mkdir -p d1/d2; ln -s d1/d2 lnk; cd lnk; pwd | sed 's|.*/||'; pwd -P | sed 's|.*/||'", stdout: "lnk\nd2\n"},
+		{name: "subshell cd", input: "mkdir d1; (cd d1; ls ..) | grep d1; ls -d d1", stdout: "d1\nd1\n"},
+
+		{name: "read", input: "echo 'a b  c ' > bar; read x y < bar; echo \"[$x][$y]\"", stdout: "[a][b  c]\n"},
+		{name: "read more vars", input: "echo a > bar; read x y < bar; echo \"[$x][$y]\"", stdout: "[a][]\n"},
+		{name: "read ifs", input: "echo 'a:b:c' > bar; IFS=: read x y < bar; echo \"[$x][$y][$IFS]\"", stdout: "[a][b:c][ \t\n]\n"},
+		{name: "read ifs spaces", input: "echo ' a : b : c ' > bar; IFS=' :' read x y z < bar; echo \"[$x][$y][$z]\"", stdout: "[a][b][c]\n"},
+		{name: "read backslash", input: `printf '%s\n' 'a\ b\\c d' > bar; read x y < bar; printf '[%s][%s]\n' "$x" "$y"`, stdout: "[a b\\c][d]\n"},
+		{name: "read raw", input: `echo 'a\ b c' > bar; read -r x y < bar; echo "[$x][$y]"`, stdout: "[a\\][b c]\n"},
+		{name: "read continuation", input: "printf 'a\\\nb\\n' > bar; read x < bar; echo $x", stdout: "ab\n"},
+		{name: "read eof", input: "printf 'a' > bar; read x < bar; echo $? $x; read x < /dev/null; echo $? $x.", stdout: "1 a\n1 .\n"},
+		{name: "read lines", input: "printf 'a\\nb\\n' > bar; 0<bar sh -c 'read x; read y; echo $y$x'", stdout: "ba\n"},
+		{name: "read heredoc", input: "read x y <<EOF\n1 2\nEOF\necho $y$x", stdout: "21\n"},
+
+		{name: "test string", input: "test a; echo $?; test ''; echo $?; test; echo $?", stdout: "0\n1\n1\n"},
+		{name: "test unary", input: "test -f foo && test -d bin && test -s foo && test ! -s a && test -z '' && test -n a && echo ok", stdout: "ok\n"},
+		{name: "test binary", input: "[ a = a ] && [ a != b ] && [ 1 -lt 2 ] && [ 2 -ge 2 ] && [ 3 -ne 2 ] && echo ok", stdout: "ok\n"},
+		{name: "test negation", input: "[ ! a = a ]; echo $?; [ ! ]; echo $?; [ ! '' ]; echo $?", stdout: "1\n0\n0\n"},
+		{name: "test parens", input: "[ \\( a = b \\) ]; echo $?; [ \\( -n a \\) ]; echo $?", stdout: "1\n0\n"},
+		{name: "test and or", input: "[ a = b -o -f foo -a -d bin ]; echo $?; [ -f foo -a ! -d bin ]; echo $?", stdout: "0\n1\n"},
+		{name: "test operator as operand", input: "[ -n = -n ]; echo $?; [ = = = ]; echo $?", stdout: "0\n0\n"},
+		{name: "test integer error", input: "[ a -eq 1 ]; echo $?", stdout: "2\n", stderr: "^.*integer expression expected\n$", skip: []string{"sh", "zsh"}},
+		{name: "test missing bracket", input: "[ a; echo $?", stdout: "2\n", stderr: "^.*\\[: missing [`]\\]'\n$", skip: []string{"sh", "zsh"}},
+
+		{name: "printf", input: `printf '%s=%d\n' a 1`, stdout: "a=1\n"},
+		{name: "printf reuse", input: `printf '%s-%s\n' a b c`, stdout: "a-b\nc-\n"},
+		{name: "printf no arg", input: `printf 'a\tb%s%d\n'`, stdout: "a\tb0\n"},
+		{name: "printf width", input: `printf '[%5s][%-5s][%.2s][%05d][%x][%X][%o][%c]\n' a b abc 42 255 255 8 xyz`, stdout: "[    a][b    ][ab][00042][ff][FF][10][x]\n"},
+		{name: "printf float", input: `printf '%.2f %e %g\n' 3.14159 1.5 0.0001`, stdout: "3.14 1.500000e+00 0.0001\n"},
+		{name: "printf escapes", input: `printf '%b|%s|\101\n' 'a\tb' 'a\tb'`, stdout: "a\tb|a\\tb|A\n"},
+		{name: "printf char code", input: `printf '%d\n' "'A"`, stdout: "65\n"},
+		{name: "printf percent", input: `printf '100%%\n'`, stdout: "100%\n"},
+		{name: "printf invalid number", input: `printf '%d\n' abc; echo $?`, stdout: "0\n1\n", stderr: "^.*abc.*\n$", skip: []string{"zsh"}},
+		{name: "printf stop", input: `printf '%b-%s\n' 'a\cb' c; echo`, stdout: "a\n"},
+
+		{name: "prefix assignment", input: "a=1; a=2 true; echo $a", stdout: "1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestBraceGroup(t *testing.T) {
+	tests := []testCase{
+		{name: "brace group", input: "{ echo a; echo b; }", stdout: "a\nb\n"},
+		{name: "brace group redirect once", input: "{ echo a; echo b; } > bar; cat bar", stdout: "a\nb\n"},
+		{name: "brace group append", input: "{ echo a; } > bar; { echo b; echo c; } >> bar; cat bar", stdout: "a\nb\nc\n"},
+		{name: "brace group stderr", input: "{ echo a; echo b >&2; } 2>&1 | cat -e", stdout: "a$\nb$\n"},
+		{name: "brace group current shell", input: "x=1; mkdir d1; { x=2; cd d1; }; echo $x; ls -a", stdout: "2\n.\n..\n"},
+		{name: "brace group status", input: "{ true; false; }; echo $?", stdout: "1\n"},
+		{name: "brace group newlines", input: "{\n  echo a\n  echo b\n}", stdout: "a\nb\n"},
+		{name: "brace group no space", input: "{ echo a;}", stdout: "a\n"},
+		{name: "brace group input", input: "printf 'a\\nb\\n' | { read x; read y; echo $y$x; }", stdout: "ba\n"},
+		{name: "brace group exit", input: "{ exit 3; }; echo no", exitCode: 3, wantErr: true},
+		{name: "brace group subshell", input: "x=1; ({ x=2; echo $x; }); echo $x", stdout: "2\n1\n"},
+		{name: "braces as arguments", input: "echo } {", stdout: "} {\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestIfClause(t *testing.T) {
+	tests := []testCase{
+		{name: "if", input: "if true; then echo yes; fi; if false; then echo no; fi", stdout: "yes\n"},
+		{name: "if else", input: "if false; then echo yes; else echo no; fi", stdout: "no\n"},
+		{name: "if elif", input: "if false; then echo 1; elif true; then echo 2; else echo 3; fi", stdout: "2\n"},
+		{name: "elif chain", input: "if false; then echo 1; elif false; then echo 2; elif ! false; then echo 3; else echo 4; fi", stdout: "3\n"},
+		{name: "no branch status", input: "false; if false; then :; fi; echo $?", stdout: "0\n"},
+		{name: "branch status", input: "if true; then false; fi; echo $?; if false; then :; else (exit 3); fi; echo $?", stdout: "1\n3\n"},
+		{name: "condition list", input: "if false; true; then echo a; fi; if true && false; then echo b; fi", stdout: "a\n"},
+		{name: "newlines", input: "if true\nthen\n  echo a\n\n  echo b\nelif false\nthen\n  :\nfi\necho c", stdout: "a\nb\nc\n"},
+		{name: "nested", input: "if if false; then false; else true; fi; then if true; then echo nested; fi; fi", stdout: "nested\n"},
+		{name: "redirect", input: "if true; then echo a; echo b >&2; fi > bar 2>&1; cat bar", stdout: "a\nb\n"},
+		{name: "pipe", input: "echo hello | if read x; then echo got $x; fi | cat -e", stdout: "got hello$\n"},
+		{name: "current shell", input: "a=1; if true; then a=2; fi; echo $a", stdout: "2\n"},
+		{name: "subshell", input: "(if true; then echo a; fi; echo b)", stdout: "a\nb\n"},
+		{name: "exit", input: "if true; then exit 3; fi; echo no", exitCode: 3, wantErr: true},
+		{name: "reserved word argument", input: "echo if then fi", stdout: "if then fi\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []testCase{
+		{name: "while", input: "i=; while [ \"$i\" != xxx ]; do i=x$i; echo $i; done", stdout: "x\nxx\nxxx\n"},
+		{name: "until", input: "i=; until [ \"$i\" = xx ]; do i=x$i; echo $i; done", stdout: "x\nxx\n"},
+		{name: "no iteration status", input: "false; while false; do :; done; echo $?; until true; do :; done; echo $?", stdout: "0\n0\n"},
+		{name: "body status", input: "i=; while [ -z \"$i\" ]; do i=x; (exit 3); done; echo $?", stdout: "3\n"},
+		{name: "newlines", input: "while\ntrue\ndo\n  echo a\n  break\ndone\necho b", stdout: "a\nb\n"},
+		{name: "break", input: "while true; do echo a; break; echo b; done; echo $?", stdout: "a\n0\n"},
+		{name: "continue", input: "i=; while [ \"$i\" != xxx ]; do i=x$i; if [ $i = xx ]; then continue; fi; echo $i; done", stdout: "x\nxxx\n"},
+		{name: "break nested", input: "while true; do while true; do echo in; break 2; done; echo no; done; echo out", stdout: "in\nout\n"},
+		{name: "continue nested", input: "i=; while [ \"$i\" != xxx ]; do i=x$i; until false; do continue 2; done; echo no; done; echo $i", stdout: "xxx\n"},
+		{name: "break in condition", input: "while break; do echo no; done; echo ok", stdout: "ok\n"},
+		{name: "break too many", input: "while true; do while true; do break 5; done; echo no; done; echo ok", stdout: "ok\n"},
+		{name: "break outside loop", input: "break; echo $?", stdout: "0\n"},
+		{name: "redirect", input: "i=; while [ \"$i\" != xx ]; do i=x$i; echo $i; done > bar; cat bar", stdout: "x\nxx\n"},
+		{name: "redirect input", input: "printf 'a\\nb\\n' > bar; while read l; do echo \"<$l>\"; done < bar", stdout: "<a>\n<b>\n"},
+		{name: "pipe", input: "printf 'a\\nb\\n' | while read l; do echo \"<$l>\"; done | cat -e", stdout: "<a>$\n<b>$\n"},
+		{name: "subshell", input: "(while true; do echo a; break; done; echo b)", stdout: "a\nb\n"},
+		{name: "pipeline environment", input: "echo a b | while read x y; do n=$x; done; echo n=$n", stdout: "n=\n"},
+		{name: "current shell", input: "a=1; while true; do a=2; break; done; echo $a", stdout: "2\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestForClause(t *testing.T) {
+	tests := []testCase{
+		{name: "for", input: "for x in a b c; do echo $x; done", stdout: "a\nb\nc\n"},
+		{name: "for glob", input: "for f in a* go.*; do echo $f; done", stdout: "a\naa\nab\nast\ngo.mod\ngo.sum\n"},
+		{name: "for glob no match", input: "for f in nomatch*; do echo $f; done", stdout: "nomatch*\n"},
+		{name: "for command substitution", input: "for x in $(echo a b) `echo c`; do echo $x; done", stdout: "a\nb\nc\n"},
+		{name: "for quoted", input: `for x in "a b" 'c d'; do echo "[$x]"; done`, stdout: "[a b]\n[c d]\n"},
+		{name: "for positional", input: "set -- a 'b c'; for x; do echo \"[$x]\"; done", stdout: "[a]\n[b c]\n"},
+		{name: "for positional newline", input: "set -- a b\nfor x\ndo\n  echo $x\ndone", stdout: "a\nb\n"},
+		{name: "for params", input: "set -- a 'b c'; for x in \"$@\" d; do echo \"[$x]\"; done", stdout: "[a]\n[b c]\n[d]\n"},
+		{name: "for empty", input: "false; for x in; do echo no; done; echo $?", stdout: "0\n"},
+		{name: "for reserved words", input: "for x in do done; do echo $x; done", stdout: "do\ndone\n"},
+		{name: "for variable", input: "for x in a b; do :; done; echo $x", stdout: "b\n"},
+		{name: "for break continue", input: "for i in 1 2 3; do for j in a b; do [ $j = b ] && continue 2; [ $i = 3 ] && break 2; echo $i$j; done; done; echo $i", stdout: "1a\n2a\n3\n"},
+		{name: "for pipe", input: "for x in a b; do echo $x; done | cat -e", stdout: "a$\nb$\n"},
+		{name: "for redirect", input: "for x in a b; do echo $x; done > bar; cat bar", stdout: "a\nb\n"},
+		{name: "for subshell", input: "(for x in a b; do echo $x; done)", stdout: "a\nb\n"},
+		{name: "command substitution fields", input: "printf '[%s]\\n' $(echo a b)", stdout: "[a]\n[b]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestCaseClause(t *testing.T) {
+	tests := []testCase{
+		{name: "case", input: "x=bar.go; case $x in *.c) echo c;; *.go|*.h) echo go;; *) echo other;; esac", stdout: "go\n"},
+		{name: "case default", input: "case x in a) echo a;; *) echo default;; esac", stdout: "default\n"},
+		{name: "case no match", input: "false; case z in a) echo a;; esac; echo $?", stdout: "0\n"},
+		{name: "case no fallthrough", input: "case a in a) echo one;; a) echo two;; esac", stdout: "one\n"},
+		{name: "case leading paren", input: "case b in (a|b) echo ab;; (*) echo no;; esac", stdout: "ab\n"},
+		{name: "case bracket", input: "case x in [a-c]) echo no;; [xyz]) echo yes;; esac", stdout: "yes\n"},
+		{name: "case question mark", input: "case abc in ?) echo no;; ???) echo yes;; esac", stdout: "yes\n"},
+		{name: "case not globbed", input: "case foo in *) echo yes;; esac; case go.mod in go.*) echo yes;; esac", stdout: "yes\nyes\n"},
+		{name: "case quoted pattern", input: `case abc in "a*") echo no;; 'a'*) echo yes;; esac`, stdout: "yes\n"},
+		{name: "case quoted star", input: `case '*' in "*") echo star;; *) echo any;; esac`, stdout: "star\n"},
+		{name: "case pattern variable", input: `p='a*'; case abc in $p) echo yes;; esac; case abc in "$p") echo no;; *) echo quoted;; esac`, stdout: "yes\nquoted\n"},
+		{name: "case empty body", input: "case a in a) ;; esac; echo $?", stdout: "0\n"},
+		{name: "case body status", input: "case a in a) false;; esac; echo $?", stdout: "1\n"},
+		{name: "case empty", input: "case a in esac; echo $?", stdout: "0\n"},
+		{name: "case newlines", input: "case x in\n  x)\n    echo x\n    ;;\n  y) echo y\nesac", stdout: "x\n"},
+		{name: "case last item without dsemi", input: "case y in x) echo x;; y) echo y; esac", stdout: "y\n"},
+		{name: "case reserved word", input: "case for in for) echo for;; esac", stdout: "for\n"},
+		{name: "case in loop", input: "for i in a b c; do case $i in b) continue;; esac; echo $i; done", stdout: "a\nc\n"},
+		{name: "case pipe", input: "echo b | case y in y) cat -e;; esac", stdout: "b$\n"},
+		{name: "case subshell", input: "(case a in a) echo sub;; b) echo no; esac)", stdout: "sub\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []testCase{
+		{name: "function", input: "f() { echo \"f:$#:$1:$2\"; }; f a b; f", stdout: "f:2:a:b\nf:0::\n"},
+		{name: "function space", input: "f () { echo a; }; f", stdout: "a\n"},
+		{name: "function newline", input: "f()\n{\n  echo a\n}\nf", stdout: "a\n"},
+		{name: "function compound body", input: "f() if true; then echo a; fi; f", stdout: "a\n"},
+		{name: "function positional", input: "set -- x y; f() { echo $1 $#; }; f a; echo $1 $#", stdout: "a 1\nx 2\n"},
+		{name: "function params", input: "g() { echo \"[$*]\"; }; f() { g \"$@\" z; }; f a 'b c'", stdout: "[a b c z]\n"},
+		{name: "function return", input: "f() { echo in; return 3; echo no; }; f; echo $?", stdout: "in\n3\n"},
+		{name: "function return status", input: "f() { false; return; }; f; echo $?", stdout: "1\n"},
+		{name: "function return in loop", input: "f() { for i in 1 2 3; do [ $i = 2 ] && return 5; echo $i; done; }; f; echo $?", stdout: "1\n5\n"},
+		{name: "function current shell", input: "f() { x=2; }; x=1; f; echo $x", stdout: "2\n"},
+		{name: "function subshell body", input: "f() (x=2; exit 4); x=1; f; echo $? $x", stdout: "4 1\n"},
+		{name: "function redirect", input: "f() { echo a; echo b; } > bar; f; cat bar", stdout: "a\nb\n"},
+		{name: "function pipe", input: "f() { echo a; echo b; }; f | cat -e", stdout: "a$\nb$\n"},
+		{name: "function pipeline positional", input: "f() { for i in 1 2 3; do echo $1 $i >&2; done; }; { f a | f b; } 2>&1 | sort", stdout: "a 1\na 2\na 3\nb 1\nb 2\nb 3\n"},
+		{name: "function pipeline environment", input: "f() { x=$1; }; x=0; f a | f b; echo $x", stdout: "0\n"},
+		{name: "function input", input: "f() { read x; echo \"<$x>\"; }; echo a | f", stdout: "<a>\n"},
+		{name: "function before path", input: "ls() { echo fake; }; ls", stdout: "fake\n"},
+		{name: "function before builtin", input: "echo() { printf 'my %s\\n' \"$@\"; }; echo a", stdout: "my a\n"},
+		{name: "function subshell", input: "f() { echo a; }; (f)", stdout: "a\n"},
+		{name: "function command substitution", input: "f() { echo a; }\necho x$(f)x y`f`y", stdout: "xax yay\n"},
+		{name: "function prefix assignment", input: "f() { echo $x; }; x=1 f; echo \"[$x]\"", stdout: "1\n[]\n"},
+		{name: "function exit", input: "f() { exit 3; }; f; echo no", exitCode: 3, wantErr: true},
+		{name: "unset function", input: "echo() { printf 'no\\n'; }; unset -f echo; echo yes", stdout: "yes\n"},
+		{name: "redefine function", input: "f() { echo a; }; f() { echo b; }; f", stdout: "b\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestAsyncLists(t *testing.T) {
+	tests := []testCase{
+		{name: "background", input: "(sleep 0.1; echo bg) & echo fg; wait", stdout: "fg\nbg\n"},
+		{name: "background status", input: "false & echo $?", stdout: "0\n"},
+		{name: "background newline", input: "echo a > bar &\nwait\ncat bar", stdout: "a\n"},
+		{name: "background in list", input: "sleep 0.1 & x=1; echo $x; wait", stdout: "1\n"},
+		{name: "background subshell environment", input: "x=1; x=2 & wait; echo $x", stdout: "1\n"},
+		{name: "background stdin", input: "echo a | { cat & wait; }", stdout: "", skip: []string{"bash"}},
+		{name: "background compound", input: "x=1; { echo in $x; } & wait", stdout: "in 1\n"},
+		{name: "background function", input: "f() { echo f $1; }; f a & wait", stdout: "f a\n"},
+		{name: "background in compound list", input: "if true; then echo a > bar & wait; fi; cat bar", stdout: "a\n"},
+		{name: "last pid", input: "echo \"[$!]\"; true & [ -n \"$!\" ] && echo set; wait", stdout: "[]\nset\n"},
+		{name: "wait pid", input: "sh -c 'exit 3' & pid=$!; wait $pid; echo $?", stdout: "3\n"},
+		{name: "wait all", input: "sh -c 'exit 3' & sh -c 'exit 4' & wait; echo $?", stdout: "0\n"},
+		{name: "wait unknown", input: "wait 999999; echo $?", stdout: "127\n"},
+		{name: "wait signaled", input: "sleep 5 & kill $!; wait $!; echo $?", stdout: "143\n"},
+		{name: "kill last pid", input: "sh -c 'echo $$ >bar; exec sleep 31' & pid=$!; while [ ! -s bar ]; do sleep 0.01; done; [ \"$(cat bar)\" = \"$pid\" ] && echo same; kill $pid; wait $pid; echo $?; kill -0 $(cat bar) 2>/dev/null || echo dead", stdout: "same\n143\ndead\n"},
+		{name: "last pid in subshell", input: "true & (echo ${!:+set}); [ \"$(echo $!)\" = \"$!\" ] && echo same; wait", stdout: "set\nsame\n"},
+		{name: "wait invalid", input: "wait x; echo $?", stdout: "1\n", stderr: "^.*wait: `x': not a pid or valid job spec\n$", skip: []string{"sh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestPipelineStatus(t *testing.T) {
+	dashSkip := []string{"sh"} // Neither pipefail nor PIPESTATUS in dash.
+	tests := []testCase{
+		{name: "last command", input: "exit 4 | cat; echo $?", stdout: "0\n"},
+		{name: "last command failure", input: "true | exit 3; echo $?", stdout: "3\n"},
+		{name: "signaled", input: "sh -c 'kill -9 $$'; echo $?", stdout: "137\n"},
+		{name: "pipefail", input: "set -o pipefail; exit 3 | exit 4 | true; echo $?", stdout: "4\n", skip: dashSkip},
+		{name: "pipefail success", input: "set -o pipefail; true | true; echo $?", stdout: "0\n", skip: dashSkip},
+		{name: "pipefail negated", input: "set -o pipefail; ! exit 2 | true; echo $?", stdout: "0\n", skip: dashSkip},
+		{name: "pipefail off", input: "set -o pipefail; set +o pipefail; exit 3 | true; echo $?", stdout: "0\n", skip: dashSkip},
+		{name: "pipefail subshell", input: "set -o pipefail; (exit 3 | true); echo $?", stdout: "3\n", skip: dashSkip},
+		{name: "pipestatus", input: "exit 1 | true | exit 3; echo $PIPESTATUS", stdout: "1 0 3\n", skip: []string{"bash", "sh"}},
+		{name: "pipestatus single", input: "false; echo $PIPESTATUS", stdout: "1\n", skip: dashSkip},
+		{name: "pipestatus signaled", input: "sh -c 'kill $$' | true; echo $PIPESTATUS", stdout: "143 0\n", skip: []string{"bash", "sh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestErrexit(t *testing.T) {
+	tests := []testCase{
+		{name: "exit on failure", input: "set -e; echo a; sh -c 'exit 3'; echo no", stdout: "a\n", exitCode: 3, wantErr: true},
+		{name: "disabled", input: "set -e; set +e; false; echo yes", stdout: "yes\n"},
+		{name: "and-or left side", input: "set -e; false && echo no; false || echo yes; echo end", stdout: "yes\nend\n"},
+		{name: "and-or last", input: "set -e; true && false; echo no", exitCode: 1, wantErr: true},
+		{name: "or failure", input: "set -e; false || false; echo no", exitCode: 1, wantErr: true},
+		{name: "negated", input: "set -e; ! true; echo yes", stdout: "yes\n"},
+		{name: "pipeline last command", input: "set -e; false | true; echo yes; true | exit 4; echo no", stdout: "yes\n", exitCode: 4, wantErr: true},
+		{name: "if condition", input: "set -e; if false; then :; fi; echo yes; if false; then :; else false; fi; echo no", stdout: "yes\n", exitCode: 1, wantErr: true},
+		{name: "loop condition", input: "set -e; while false; do :; done; until true; do :; done; echo yes", stdout: "yes\n"},
+		{name: "loop body", input: "set -e; for i in a b; do echo $i; false; done; echo no", stdout: "a\n", exitCode: 1, wantErr: true},
+		{name: "brace group ignored failure", input: "set -e; { false && true; }; echo yes", stdout: "yes\n"},
+		{name: "subshell", input: "set -e; (false && true); echo no", exitCode: 1, wantErr: true},
+		{name: "subshell inherits", input: "set -e; (false; echo no); echo no", exitCode: 1, wantErr: true},
+		{name: "function", input: "set -e; f() { false && true; }; f; echo no", exitCode: 1, wantErr: true},
+		{name: "function in condition", input: "set -e; f() { false; echo x; }; f || echo no; echo yes", stdout: "x\nyes\n"},
+		{name: "pipeline element", input: "set -e; { false; echo no; } | cat; echo yes", stdout: "yes\n"},
+		{name: "subshell in condition", input: "set -e; if (false; echo x); then echo then; fi; (false; echo y) || echo or; echo end", stdout: "x\nthen\ny\nend\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestXtrace(t *testing.T) {
+	tests := []testCase{
+		{name: "simple command", input: "set -x; echo a \"b c\" ''", stdout: "a b c \n", stderr: "+ echo a 'b c' ''\n", skip: []string{"sh"}},
+		{name: "expanded fields", input: "x=a; set -x; echo $x \"$x b\" *a", stdout: "a a b a aa bara\n", stderr: "+ echo a 'a b' a aa bara\n", skip: []string{"sh"}},
+		{name: "assignments", input: "set -x; a=1 b=$a", stderr: "+ a=1\n+ b=1\n", skip: []string{"sh"}},
+		{name: "prefix assignments", input: "set -x; x='a b' true", stderr: "+ x='a b'\n+ true\n", skip: []string{"sh"}},
+		{name: "special builtin", input: "set -x; x=1 :", stderr: "+ x=1\n+ :\n", skip: []string{"sh"}},
+		{name: "redirections", input: "set -x; echo a 2>/dev/null >/dev/null", stderr: "+ echo a\n"},
+		{name: "function", input: "f() { echo $1; }; set -x; f a", stdout: "a\n", stderr: "+ f a\n+ echo a\n"},
+		{name: "disabled", input: "set -x; set +x; echo a", stdout: "a\n", stderr: "+ set +x\n"},
+		{name: "ps4", input: "PS4='> '; set -x; true", stderr: "> true\n"},
+		{name: "ps4 expansion", input: "x=v; PS4='[$x] '; set -x; true", stderr: "[v] true\n"},
+		{name: "ps4 assignment", input: "set -x; PS4='> '; true", stderr: "+ PS4='> '\n> true\n", skip: []string{"sh"}},
+		{name: "subshell", input: "set -x; set -- p q; (echo a)", stdout: "a\n", stderr: "+ set -- p q\n+ echo a\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestShellOptions(t *testing.T) {
+	nounsetSkip := []string{"sh", "bash -c", "bash --posix -c"} // Different exit codes.
+	tests := []testCase{
+		{name: "nounset", input: "set -u; echo $gosh2unset; echo no", stderr: "^[a-z0-9]+:( line 1:)? gosh2unset: (parameter not set|unbound variable)\n$", exitCode: 1, wantErr: true, skip: nounsetSkip},
+		{name: "nounset positional", input: "set -u; echo $1; echo no", stderr: "^[a-z0-9]+:( line 1:)? \\$?1: (parameter not set|unbound variable)\n$", exitCode: 1, wantErr: true, skip: nounsetSkip},
+		{name: "nounset length", input: "set -u; echo ${#gosh2unset}; echo no", exitCode: 1, wantErr: true, skip: nounsetSkip},
+		{name: "nounset exemptions", input: "set -u; echo \"$@\" $* ${gosh2unset-d} ${gosh2unset:+a} ${gosh2unset=x}; echo $gosh2unset", stdout: "d x\nx\n"},
+		{name: "nounset off", input: "set -u; set +u; echo \"[$gosh2unset]\"", stdout: "[]\n"},
+		{name: "nounset set -o", input: "set -o nounset; set +o | grep nounset; set +o nounset; set -o | grep nounset", stdout: "set -o nounset\nnounset        \toff\n", skip: []string{"sh"}},
+		{name: "noglob", input: "set -f\necho *a; set +f\necho *a", stdout: "*a\na aa bara\n"},
+		{name: "noglob set -o", input: "set -o noglob\necho *a; set -o | grep noglob", stdout: "*a\nnoglob         \ton\n", skip: []string{"sh"}},
+		{name: "flags", input: "case $- in *f*|*u*) echo no;; esac; set -fu; case $- in *f*) case $- in *u*) echo yes;; esac;; esac", stdout: "yes\n"},
+		{name: "flags only", input: "set -fu; echo $-", stdout: "fu\n", skip: []string{"bash", "sh"}},
+		{name: "noexec", input: "echo a; set -n; echo b\necho c", stdout: "a\n"},
+		{name: "noexec set -o", input: "set -o noexec\nset +o noexec\necho a", stdout: ""},
+		{name: "verbose", input: "set -v\necho a; (echo b)\nx=$(echo c)\ncat <<EOF\nd\nEOF", stdout: "a\nb\nd\n", stderr: "echo a; (echo b)\nx=$(echo c)\ncat <<EOF\nd\nEOF\n", skip: []string{"sh"}},
+		{name: "verbose off", input: "set -v; echo a\nset +v\necho b", stdout: "a\nb\n", stderr: "set +v\n", skip: []string{"sh"}},
+		{name: "unsupported option", input: "set -h; echo $?; set -o vi; echo $?", stdout: "2\n2\n", stderr: "^gosh2: set: -h: invalid option\ngosh2: set: vi: invalid option name\n$", skip: []string{"bash", "sh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestNoclobber(t *testing.T) {
+	tests := []testCase{
+		{name: "existing file", input: "set -C; echo a > bar; echo b > bar; echo $?; cat bar", stdout: "1\na\n", stderr: "^[a-z0-9]+:( line 1:)? bar: cannot overwrite existing file\n$", skip: []string{"sh"}},
+		{name: "existing file pipeline", input: "set -C; echo a > bar; echo b > bar | echo c; echo $?", stdout: "c\n0\n", stderr: "^[a-z0-9]+:( line 1:)? bar: cannot overwrite existing file\n$", skip: []string{"sh"}},
+		{name: "existing file function", input: "set -C; echo a > bar; f() { echo b; } > bar; f; echo $?", stdout: "1\n", stderr: "^[a-z0-9]+:( line 1:)? bar: cannot overwrite existing file\n$", skip: []string{"sh"}},
+		{name: "existing file special builtin", input: "set -C; echo a > bar; : > bar; echo no", stderr: "^[a-z0-9]+:( line 1:)? bar: cannot overwrite existing file\n$", exitCode: 1, wantErr: true, skip: []string{"sh", "bash"}},
+		{name: "new file", input: "set -C; rm -f bar; echo a > bar; cat bar", stdout: "a\n"},
+		{name: "clobber", input: "set -C; echo a > bar; echo b >| bar; cat bar", stdout: "b\n"},
+		{name: "clobber off", input: "echo a >| bar; cat bar", stdout: "a\n"},
+		{name: "non regular file", input: "set -C; echo a > /dev/null && echo ok", stdout: "ok\n"},
+		{name: "append", input: "set -C; echo a > bar; echo b >> bar; cat bar", stdout: "a\nb\n"},
+		{name: "set +C", input: "set -C; echo a > bar; set +C; echo b > bar; cat bar", stdout: "b\n"},
+		{name: "subshell", input: "set -o noclobber; echo a > bar; (echo b > bar) 2>/dev/null || echo failed; cat bar", stdout: "failed\na\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestCloseFD(t *testing.T) {
+	tests := []testCase{
+		{name: "stderr", input: "ls /gosh2nonexistent 2>&- || echo failed", stdout: "failed\n"},
+		{name: "stdout", input: "echo a >&- || echo failed", stdout: "failed\n", stderr: "^.*echo: write error: .*[Bb]ad file descriptor\n$", skip: []string{"sh"}},
+		{name: "stdin", input: "cat <&- 2>/dev/null || echo failed", stdout: "failed\n"},
+		{name: "compound", input: "{ ls /gosh2nonexistent; echo a; } 2>&-", stdout: "a\n"},
+		{name: "child stderr", input: "sh -c 'test -e " + selfFD() + "/2 && echo open || echo closed' 2>&-", stdout: "closed\n"},
+		{name: "child stdin", input: "sh -c 'test -e " + selfFD() + "/0 && echo open || echo closed' <&-", stdout: "closed\n"},
+		{name: "exec child stderr", input: "exec 2>&-; sh -c 'test -e " + selfFD() + "/2 && echo open || echo closed'", stdout: "closed\n"},
+		{name: "extra fd", input: "cat " + selfFD() + "/7 7<foo 7<&- 2>/dev/null || echo failed", stdout: "failed\n"},
+		{name: "extra fd child", input: "sh -c 'echo a >&5' 5>bar 5>&- 2>/dev/null || echo failed; cat bar", stdout: "failed\n"},
+		{name: "dup closed fd", input: "exec 3>&-; echo a >&3; echo after", stdout: "after\n", stderr: "^[a-z0-9]+:( line)?( 1:)? 3: [Bb]ad file descriptor\n$"},
+		{name: "dup fd closed in command", input: "echo a 5>bar 5>&- >&5; echo after $?", stdout: "after 1\n", stderr: "^[a-z0-9]+:( line 1:)? 5: [Bb]ad file descriptor\n$", skip: []string{"sh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	// Run with a low descriptor limit, for leaks to fail the loops early.
+	var limit syscall.Rlimit
+	require.NoError(t, syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit), "failed to get the descriptor limit")
+	low := limit
+	low.Cur = min(limit.Cur, 64)
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &low), "failed to set the descriptor limit")
+	t.Cleanup(func() { require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)) })
+
+	loop := func(body string) string {
+		return "i=0; while [ $i -lt 200 ]; do " + body + "\ni=$((i+1)); done; echo $i"
+	}
+	tests := []testCase{
+		{name: "append", input: loop("echo $i >> bar") + "; tail -n 1 bar", stdout: "200\n199\n"},
+		{name: "input", input: loop("read l < foo"), stdout: "200\n"},
+		{name: "heredoc", input: loop("cat >/dev/null <<EOF\na\nEOF"), stdout: "200\n"},
+		{name: "compound", input: loop("{ echo a; } 2>/dev/null >bar"), stdout: "200\n"},
+		{name: "function", input: "f() { echo a; } >bar; " + loop("f"), stdout: "200\n"},
+		{name: "pipeline", input: loop("echo a | cat >bar 2>/dev/null"), stdout: "200\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestExecRedirect(t *testing.T) {
+	tests := []testCase{
+		{name: "fd", input: "exec 3>bar; echo a >&3; sh -c 'echo b >&3'; exec 3>&-; cat bar", stdout: "a\nb\n"},
+		{name: "closed fd", input: "exec 3>bar; exec 3>&-; sh -c 'echo a >&3' 2>/dev/null || echo closed", stdout: "closed\n"},
+		{name: "input fd", input: "printf 'a\\nb\\n' > bar; exec 4<bar; read x <&4; read y <&4; echo $x $y", stdout: "a b\n"},
+		{name: "stdout", input: "exec >bar; echo a; cat bar >&2", stderr: "a\n"},
+		{name: "stderr", input: "exec 2>/dev/null; ls /gosh2nonexistent; echo a", stdout: "a\n"},
+		{name: "stdin", input: "{ exec <foo; read l; echo $l; } <a", stdout: "foocontent\n"},
+		{name: "group redirect", input: "{ exec >bar; echo a; } >baz; echo b; cat bar", stdout: "b\na\n"},
+		{name: "group", input: "{ exec >bar; }; echo a; cat bar >&2", stderr: "a\n"},
+		{name: "pipeline", input: "exec >bar | cat; echo a", stdout: "a\n"},
+		{name: "command", input: "exec sh -c 'echo a'; echo no", stdout: "a\n"},
+		{name: "command fd", input: "(exec 3>bar; exec sh -c 'echo a >&3'); cat bar", stdout: "a\n"},
+		{name: "command replaces", input: "(sh -c 'echo $PPID'; exec sh -c 'echo $$') | uniq | wc -l | tr -d ' '", stdout: "1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestExpansion(t *testing.T) {
+	tests := []testCase{
+		{name: "lazy command substitution", input: "false && echo $(echo no >&2); true || echo `echo no >&2`; echo ok", stdout: "ok\n"},
+		{name: "loop re-expanded", input: "for i in 1 2; do echo $(echo a$i) \"`echo b$i`\"; done", stdout: "a1 b1\na2 b2\n"},
+		{name: "while re-expanded", input: "i=0; while [ $i -lt 2 ]; do i=$(expr $i + 1); echo $i; done", stdout: "1\n2\n"},
+		{name: "noglob same line", input: "set -f; echo a*; set +f; echo a*", stdout: "a*\na aa ab ast\n"},
+		{name: "glob parameter", input: "x='a?'; echo $x \"$x\"", stdout: "aa ab a?\n"},
+		{name: "glob command substitution", input: "echo $(echo 'a?') \"$(echo 'a?')\"", stdout: "aa ab a?\n"},
+		{name: "glob directories", input: "echo */ b*/mye*", stdout: "bin/ bin/myecho\n"},
+		{name: "glob hidden", input: ": > .hidden; echo *den .h*", stdout: "*den .hidden\n"},
+		{name: "quoted substitution", input: "echo \"$(echo \"a  b\")\" \"`echo \"c\"`\"", stdout: "a  b c\n"},
+		{name: "substitution stdin", input: "echo hi | { x=$(cat); echo \"[$x]\"; }; printf 'a\\nb\\n' | while read l; do echo \"$l:$(cat)\"; done", stdout: "[hi]\na:b\n"},
+		{name: "substitution status", input: "x=$(exit 3); echo $?; x=$(true); echo $?", stdout: "3\n0\n"},
+		{name: "tilde", input: "HOME=/h; echo ~ ~/x \"~\" x~ ~/\"y\"; a=~/z; echo $a", stdout: "/h /h/x ~ x~ /h/y\n/h/z\n"},
+		{name: "quoted glob", input: `echo "a*" 'a*' a\* a"*" a*`, stdout: "a* a* a* a* a aa ab ast\n"},
+		{name: "quoted case pattern", input: `case ab in "a*") echo no;; "a"*) echo yes;; esac`, stdout: "yes\n"},
+		{name: "backslashes", input: `printf '%s\n' a\\b "a\\b" "\a" '\a' \a "\$x"`, stdout: "a\\b\na\\b\n\\a\n\\a\na\n$x\n"},
+		{name: "nested quotes", input: `printf '%s\n' "${y:-"a  b"}" ${y:-"c  d"} "${y:-'e'}"`, stdout: "a  b\nc  d\n'e'\n"},
+		{name: "heredoc expansion", input: "cat <<EOF\na $(echo sub) $x \"q\" c\nEOF", stdout: "a sub  \"q\" c\n"},
+		{name: "heredoc backslashes", input: "x=1; cat <<EOF\n\\$x \\\"$x\\\" \\a `echo b` ${y:-c}\nd \\\ne\nEOF", stdout: "$x \\\"1\\\" \\a b c\nd e\n"},
+		{name: "heredoc quoted delimiter", input: "x=1; cat <<'EOF'; cat <<\"E\"OF; cat <<E\\OF\n$x \\$x\nEOF\n$x\nEOF\n`x`\nEOF", stdout: "$x \\$x\n$x\n`x`\n"},
+		{name: "heredoc strip tabs", input: "x=1; cat <<-EOF\n\ta\t$x\n\t\tEOF\necho b", stdout: "a\t1\nb\n"},
+		{name: "heredoc pipeline", input: "cat <<EOF | tr a-z A-Z; echo b\n$(echo a)\nEOF", stdout: "A\nb\n"},
+		{name: "quoting preserved", input: "f() { printf '%s\\n' \"a  *\" 'b  $x' \\* \"${y:-\"c  d\"}\" `echo e`; }; (f)", stdout: "a  *\nb  $x\n*\nc  d\ne\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestFieldSplitting(t *testing.T) {
+	tests := []testCase{
+		{name: "default ifs", input: "x=' a  b\tc\nd '; printf '[%s]' $x pre${x}post \"$x\"", stdout: "[a][b][c][d][pre][a][b][c][d][post][ a  b\tc\nd ]"},
+		{name: "command substitution", input: "for f in $(printf 'a b\nc'); do echo $f; done; set -- $(echo 'd  e'); echo $#", stdout: "a\nb\nc\n2\n"},
+		{name: "non whitespace ifs", input: "IFS=:; x='a::b:'; printf '[%s]' $x; x=':a'; printf '[%s]' $x", stdout: "[a][][b][][a]"},
+		{name: "mixed ifs", input: "IFS=' :'; x=' a : b :: c '; printf '[%s]' $x", stdout: "[a][b][][c]"},
+		{name: "empty ifs", input: "IFS=; x='a b'; printf '[%s]' $x", stdout: "[a b]"},
+		{name: "unset ifs", input: "IFS=:; unset IFS; x='a b'; printf '[%s]' $x", stdout: "[a][b]"},
+		{name: "empty fields", input: "x=; printf '[%s]' $x; echo $#; printf '[%s]' \"$x\" $x''", stdout: "[]0\n[][]"},
+		{name: "positional parameters", input: "set -- 'a b' c; printf '[%s]' $@ $* \"$*\"; IFS=-; printf '[%s]' \"$*\" $*", stdout: "[a][b][c][a][b][c][a b c][a b-c][a b][c]"},
+		{name: "operand", input: "printf '[%s]' ${u:-a b} \"${u:-a b}\" ${u:-\"a b\"}; IFS=,; printf '[%s]' ${u:-1,2}", stdout: "[a][b][a b][a b][1][2]"},
+		{name: "no splitting", input: "x='a  b'; y=$x; printf '[%s]' \"$y\"; IFS=:; x='c:d'; y=$x; printf '[%s]' \"$y\"", stdout: "[a  b][c:d]"},
+		{name: "pathname expansion", input: "x='a* b'; printf '[%s]' $x", stdout: "[a][aa][ab][ast][b]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	errorSkip := []string{"sh", "bash --posix -c"} // Exit with 2 and 127.
+	tests := []testCase{
+		{name: "precedence", input: "echo $((1+2*3)) $(( (1+2)*3 )) $((2+3<<1)) $((1|2^3&4)) $((1<2==1))", stdout: "7 9 10 3 1\n"},
+		{name: "arithmetic", input: "echo $((7/2)) $((-7/2)) $((7%3)) $((-7%3)) $((1<<4)) $((256>>2))", stdout: "3 -3 1 -1 16 64\n"},
+		{name: "bitwise", input: "echo $((6&3)) $((6|3)) $((6^3)) $((~5))", stdout: "2 7 5 -6\n"},
+		{name: "unary", input: "echo $((-3)) $((+3)) $((- -3)) $((!0)) $((!5))", stdout: "-3 3 3 1 0\n"},
+		{name: "comparisons", input: "echo $((1<2)) $((2<=1)) $((3>2)) $((3>=4)) $((1==1)) $((1!=1))", stdout: "1 0 1 0 1 0\n"},
+		{name: "logical", input: "echo $((1&&0)) $((0||2)) $((2&&3))", stdout: "0 1 1\n"},
+		{name: "conditional", input: "echo $((1?10:20)) $((0?1:0?2:3))", stdout: "10 3\n"},
+		{name: "variables", input: "x=5 y=3; echo $((x*y)) $(($x-$y)) $((${x}+1)) $((unset_var))", stdout: "15 2 6 0\n"},
+		{name: "assignment", input: "echo $((z=4)) $((z+=2)) $((z-=1)) $((z*=3)) $((z/=2)) $((z%=4)) $((z<<=3)) $((z>>=1)) $((z&=7)) $((z|=8)) $((z^=1)); echo $z $((a=b=2)) $a $b", stdout: "4 6 5 15 7 3 24 12 4 12 13\n13 2 2 2\n"},
+		{name: "short circuit", input: "echo $((0 && (q=1))) $((1 || (q=1))) $((1 ? 2 : (q=1))) ${q-unset} $((0 && 1/0))", stdout: "0 1 2 unset 0\n"},
+		{name: "constants", input: "echo $((010)) $((0x1F)) $((0X10))", stdout: "8 31 16\n"},
+		{name: "overflow", input: "echo $((9223372036854775807+1)) $((-9223372036854775807-1))", stdout: "-9223372036854775808 -9223372036854775808\n"},
+		{name: "expanded", input: "x=2; echo \"$(( x + 1 ))\" $(( $(echo 2) * 3 )) `echo $((2*2))` \"${u:-$((4+4))}\"", stdout: "3 6 4 8\n"},
+		{name: "loop", input: "i=0; while [ $i -lt 3 ]; do i=$((i+1)); done; echo $i", stdout: "3\n"},
+		{name: "subshell", input: "echo $( (echo sub) )", stdout: "sub\n"},
+		{name: "subshell without blank", input: "echo $((echo a); (echo b)) \"$((echo c) | tr c d)\"", stdout: "a b d\n", skip: []string{"sh"}},
+		{name: "division by zero", input: "echo $((1/0)); echo after", exitCode: 1, wantErr: true, skip: errorSkip},
+		{name: "modulo by zero", input: "echo $((1%0)); echo after", exitCode: 1, wantErr: true, skip: errorSkip},
+		{name: "syntax error", input: "echo $((1+)); echo after", exitCode: 1, wantErr: true, skip: errorSkip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, run(tt))
+	}
+}
+
+func TestTrap(t *testing.T) {
+	tests := []testCase{
+		{name: "exit", input: "trap 'echo bye' EXIT; echo a", stdout: "a\nbye\n"},
+		{name: "exit status", input: "trap 'echo $?' EXIT; false", stdout: "1\n", exitCode: 1, wantErr: true},
+		{name: "exit builtin", input: "trap 'echo bye' EXIT; exit 2; echo no", stdout: "bye\n", exitCode: 2, wantErr: true},
+		{name: "exit in trap", input: "trap 'exit 3' EXIT; true", exitCode: 3, wantErr: true},
+		{name: "exit subshell", input: "trap 'echo bye' EXIT; (echo a); (trap 'echo sub' EXIT; echo b)", stdout: "a\nb\nsub\nbye\n"},
+		{name: "signal", input: "trap 'echo got' TERM; kill -TERM $$; echo after", stdout: "got\nafter\n"},
+		{name: "signal status preserved", input: "trap false USR1; kill -USR1 $$; echo $?", stdout: "0\n"},
+		{name: "signal at exit", input: "trap 'echo got' USR1; trap 'echo bye' EXIT; kill -USR1 $$", stdout: "got\nbye\n"},
+		{name: "signal by name", input: "trap 'echo got' USR2; kill -s USR2 $$; kill -l 140", stdout: "got\nUSR2\n"},
+		{name: "wait interrupted", input: "trap 'echo got' USR1; pid=$$; (sleep 0.2; kill -USR1 $pid) & wait; echo $?", stdout: "got\n138\n", skip: []string{"sh"}},
+		{name: "ignored inherited", input: "trap '' INT; sh -c 'kill -INT $$; echo survived'", stdout: "survived\n"},
+		{name: "handled reset", input: "trap 'echo no' USR1; sh -c 'kill -USR1 $$; echo no'; echo $?", stdout: "138\n"},
+		{name: "killed status", input: "sh -c 'kill -TERM $$'; echo $?", stdout: "143\n"},
+		{name: "subshell killed status", input: "f() ( sh -c 'kill -TERM $PPID'; echo no ); f; echo $?", stdout: "143\n"},
 	}
 
 	for _, tt := range tests {
@@ -231,6 +872,120 @@ func TestLeftRedirect(t *testing.T) {
 	}
 }
 
+// ptyOutput accumulates the output of a pty.
+type ptyOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	pos int // Position after the last expected output.
+}
+
+func (o *ptyOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+// expect waits for the given output past the previous one.
+func (o *ptyOutput) expect(t *testing.T, s string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		idx := strings.Index(o.buf.String()[o.pos:], s)
+		if idx == -1 {
+			return false
+		}
+		o.pos += idx + len(s)
+		return true
+	}, 10*time.Second, 10*time.Millisecond, "output %q not found in:\n%s", s, &o.buf)
+}
+
+func TestJobControl(t *testing.T) {
+	setupEnv(t)
+
+	// Run an interactive shell in a pty. As the input is echoed, the expected
+	// outputs are quoted in the commands so they don't match the echo.
+	cmd := exec.Command(os.Args[0], "-i")
+	ptmx, err := pty.Start(cmd)
+	require.NoError(t, err, "failed to start the shell in a pty")
+	defer func() { _ = ptmx.Close() }() // Best effort.
+	out := &ptyOutput{}
+	go func() { _, _ = io.Copy(out, ptmx) }() // Until the pty is closed.
+	send := func(s string) {
+		_, err := ptmx.WriteString(s)
+		require.NoError(t, err, "failed to write to the pty")
+	}
+
+	// ^Z stops the foreground job, ready once it printed its output.
+	send("sh -c 'echo re\"\"ady; exec sleep 10'\n")
+	out.expect(t, "ready\r\n")
+	send("\x1a")
+	out.expect(t, "[1]+  Stopped                 sh -c ")
+	send("echo st\"\"atus $?\n")
+	out.expect(t, "status 148\r\n")
+
+	// Background jobs get the next job number and become the current job.
+	send("sleep 10 &\n")
+	out.expect(t, "[2] ")
+	send("jobs\n")
+	out.expect(t, "[1]-  Stopped                 sh -c ")
+	out.expect(t, "[2]+  Running                 sleep 10 &\r\n")
+
+	// bg resumes the job in the background, fg in the foreground, receiving ^C.
+	send("bg %-\n")
+	out.expect(t, "[1]- sh -c ")
+	send("jobs %1\n")
+	out.expect(t, "[1]-  Running                 sh -c ")
+	send("fg %1\n")
+	out.expect(t, "sleep 10'\r\n")
+	send("\x03")
+	send("echo st\"\"atus $?\n")
+	out.expect(t, "status 130\r\n")
+
+	// The remaining job is the current one.
+	send("jobs -p %+ | wc -l; kill -- -$!; wait %%; echo st\"\"atus $?\n")
+	out.expect(t, "1\r\n")
+	out.expect(t, "status 143\r\n")
+	send("fg\n")
+	out.expect(t, "fg: current: no such job")
+
+	send("exit 0\n")
+	require.NoError(t, cmd.Wait(), "shell failed")
+}
+
+func TestInteractiveSignals(t *testing.T) {
+	setupEnv(t)
+
+	cmd := exec.Command(os.Args[0], "-i")
+	ptmx, err := pty.Start(cmd)
+	require.NoError(t, err, "failed to start the shell in a pty")
+	defer func() { _ = ptmx.Close() }() // Best effort.
+	out := &ptyOutput{}
+	go func() { _, _ = io.Copy(out, ptmx) }() // Until the pty is closed.
+	send := func(s string) {
+		_, err := ptmx.WriteString(s)
+		require.NoError(t, err, "failed to write to the pty")
+	}
+
+	// The interactive shell survives ^C and SIGTERM.
+	send("echo re\"\"ady\n")
+	out.expect(t, "ready\r\n")
+	send("\x03")
+	send("kill -TERM $$; echo al\"\"ive\n")
+	out.expect(t, "alive\r\n")
+
+	// Trapped signals run their action once the command is done.
+	send("trap 'echo tr\"\"apped' INT; echo se\"\"t\n")
+	out.expect(t, "set\r\n")
+	send("\x03")
+	send("echo do\"\"ne\n")
+	out.expect(t, "done\r\n")
+	out.expect(t, "trapped\r\n")
+
+	send("exit 0\n")
+	require.NoError(t, cmd.Wait(), "shell failed")
+}
+
 // NOTE: These tests can't be run in parallel because they modify the environment, cwd, and other global state.
 func run(tt testCase) func(t *testing.T) {
 	return func(t *testing.T) {
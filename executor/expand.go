@@ -0,0 +1,535 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// fieldsBuilder accumulates the fields resulting from the expansion of a word.
+type fieldsBuilder struct {
+	fields []string
+	cur    strings.Builder
+	keep   bool // True if the current field must be kept even if empty.
+
+	pattern strings.Builder // Current field as a pattern, quoted parts escaped.
+	glob    bool            // True if the current field has unquoted pattern characters.
+
+	noSplit bool   // True if neither field splitting nor pathname expansion occurs, i.e. assignments and redirections.
+	noGlob  bool   // True if pathname expansion is disabled, i.e. set -f.
+	ifs     string // Field separators, i.e. IFS.
+
+	splitLiterals bool // True if unquoted literals are split too, i.e. within the operand of ${name:-word}.
+}
+
+// write appends the given string to the current field.
+// Quoted strings are kept even when empty.
+func (f *fieldsBuilder) write(s string, quoted bool) {
+	f.cur.WriteString(s)
+	if quoted {
+		f.pattern.WriteString(escapePattern(s))
+	} else {
+		f.pattern.WriteString(s)
+		f.glob = f.glob || strings.ContainsAny(s, "*?[")
+	}
+	if quoted || s != "" {
+		f.keep = true
+	}
+}
+
+// writeLiteral appends the given literal source to the current field, removing the backslashes
+// quoting the next character. Within double quotes, they only do so before '$', '`', '"', '\'
+// and newline, and are kept otherwise. Escaped newlines are removed.
+func (f *fieldsBuilder) writeLiteral(s string, quoted bool) {
+	for {
+		i := strings.IndexByte(s, '\\')
+		if i == -1 || i == len(s)-1 {
+			f.writeUnescaped(s, quoted)
+			return
+		}
+		f.writeUnescaped(s[:i], quoted)
+		_, n := utf8.DecodeRuneInString(s[i+1:])
+		switch c := s[i+1]; {
+		case c == '\n':
+			// Line continuation, removed.
+		case quoted && strings.IndexByte("$`\"\\", c) == -1:
+			f.write(s[i:i+1+n], true)
+		default:
+			f.write(s[i+1:i+1+n], true)
+		}
+		s = s[i+1+n:]
+	}
+}
+
+// writeUnescaped appends the given literal without backslashes to the current field,
+// split when within an unquoted operand.
+func (f *fieldsBuilder) writeUnescaped(s string, quoted bool) {
+	if f.splitLiterals && !quoted {
+		f.writeFields(s)
+		return
+	}
+	f.write(s, quoted)
+}
+
+// split ends the current field and starts a new one.
+// Fields with unquoted pattern characters are replaced by the matching pathnames, if any.
+func (f *fieldsBuilder) split() {
+	if f.keep {
+		var matches []string
+		if f.glob && !f.noSplit && !f.noGlob {
+			matches = expandPathname(f.pattern.String())
+		}
+		if len(matches) == 0 {
+			matches = []string{f.cur.String()}
+		}
+		f.fields = append(f.fields, matches...)
+	}
+	f.cur.Reset()
+	f.pattern.Reset()
+	f.keep = false
+	f.glob = false
+}
+
+// separate separates the values of $@ and $*, ending the current field,
+// or with a space when no field splitting occurs.
+func (f *fieldsBuilder) separate() {
+	if f.noSplit {
+		f.write(" ", true)
+		return
+	}
+	f.split()
+}
+
+// writeExpansion appends the given expansion result to the current field,
+// split into fields unless quoted.
+func (f *fieldsBuilder) writeExpansion(s string, quoted bool) {
+	if quoted {
+		f.write(s, true)
+		return
+	}
+	f.writeFields(s)
+}
+
+// writeFields appends the given unquoted expansion result to the current field,
+// starting a new field on each IFS delimiter: either IFS whitespaces or a single
+// non-whitespace IFS character along with the IFS whitespaces around it.
+// Leading IFS whitespaces don't start a field while other delimiters always end one,
+// even if empty. An empty IFS disables field splitting.
+func (f *fieldsBuilder) writeFields(s string) {
+	if f.noSplit || f.ifs == "" {
+		f.write(s, false)
+		return
+	}
+	isIFS := func(r rune) bool { return strings.ContainsRune(f.ifs, r) }
+	isIFSSpace := func(r rune) bool { return isIFS(r) && strings.ContainsRune(" \t\n", r) }
+
+	for s != "" {
+		i := strings.IndexFunc(s, isIFS)
+		if i == -1 {
+			f.write(s, false)
+			return
+		}
+		f.write(s[:i], false)
+
+		// Skip the delimiter: IFS whitespaces around at most one non-whitespace IFS character.
+		s = strings.TrimLeftFunc(s[i:], isIFSSpace)
+		if r, n := utf8.DecodeRuneInString(s); s != "" && !isIFSSpace(r) && isIFS(r) {
+			s = strings.TrimLeftFunc(s[n:], isIFSSpace)
+			f.keep = true
+		}
+		f.split()
+	}
+}
+
+// expandWord performs the expansion of the given word and returns the resulting fields:
+// tilde expansion, parameter expansion, command substitution, field splitting
+// and pathname expansion, along with quote removal.
+// Command substitutions report their errors on stderr.
+func expandWord(sh *Shell, word ast.Word, stdin io.Reader, stderr io.Writer) ([]string, error) {
+	f := &fieldsBuilder{noGlob: sh.opts["noglob"], ifs: sh.ifs()}
+	if err := expandParts(sh, f, expandTilde(sh, word), false, stdin, stderr); err != nil {
+		return nil, err
+	}
+	f.split()
+	return f.fields, nil
+}
+
+// expandWords expands the given words and returns the resulting fields.
+func expandWords(sh *Shell, words []ast.Word, stdin io.Reader, stderr io.Writer) ([]string, error) {
+	var out []string
+	for _, w := range words {
+		fields, err := expandWord(sh, w, stdin, stderr)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, fields...)
+	}
+	return out, nil
+}
+
+// expandWordString expands the given word into a single string,
+// used where no field separation occurs like assignments and redirections.
+func expandWordString(sh *Shell, word ast.Word, stdin io.Reader, stderr io.Writer) (string, error) {
+	f := &fieldsBuilder{noSplit: true}
+	if err := expandParts(sh, f, expandTilde(sh, word), false, stdin, stderr); err != nil {
+		return "", err
+	}
+	return f.cur.String(), nil
+}
+
+// expandPattern expands the given word into a pattern.
+// Quoted parts are escaped so they match literally.
+func expandPattern(sh *Shell, word ast.Word, stdin io.Reader, stderr io.Writer) (string, error) {
+	f := &fieldsBuilder{noSplit: true}
+	if err := expandParts(sh, f, expandTilde(sh, word), false, stdin, stderr); err != nil {
+		return "", err
+	}
+	return f.pattern.String(), nil
+}
+
+// expandTilde returns the given word with its tilde-prefix, if any, replaced by the home directory:
+// the one of the current user, i.e. HOME, for ~ and the one of the given user for ~name.
+// The prefix goes up to the first slash and must be unquoted.
+func expandTilde(sh *Shell, word ast.Word) ast.Word {
+	if len(word) == 0 {
+		return word
+	}
+	l, ok := word[0].(*ast.Literal)
+	if !ok || !strings.HasPrefix(l.Value, "~") {
+		return word
+	}
+	name, rest, found := strings.Cut(l.Value[1:], "/")
+	if (!found && len(word) > 1) || strings.Contains(name, `\`) {
+		return word
+	}
+
+	var home string
+	if name == "" {
+		home, ok = sh.getVar("HOME")
+		if !ok {
+			u, err := user.Current()
+			if err != nil {
+				return word
+			}
+			home = u.HomeDir
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return word
+		}
+		home = u.HomeDir
+	}
+
+	// The home directory is not subject to field splitting nor pathname expansion.
+	out := ast.Word{&ast.SingleQuoted{Value: home}}
+	if found {
+		out = append(out, &ast.Literal{Value: "/" + rest})
+	}
+	return append(out, word[1:]...)
+}
+
+// expandParts writes the expansion of the given parts to the fields builder.
+// quoted is set within double quotes.
+func expandParts(sh *Shell, f *fieldsBuilder, parts []ast.WordPart, quoted bool, stdin io.Reader, stderr io.Writer) error {
+	for _, part := range parts {
+		switch p := part.(type) {
+		case *ast.Literal:
+			f.writeLiteral(p.Value, quoted)
+		case *ast.SingleQuoted:
+			f.write(p.Value, true)
+		case *ast.DoubleQuoted:
+			// Make sure "" yields an empty field.
+			// NOTE: Not done otherwise so "$@" can yield no field at all.
+			if len(p.Parts) == 0 {
+				f.write("", true)
+				continue
+			}
+			if err := expandParts(sh, f, p.Parts, true, stdin, stderr); err != nil {
+				return err
+			}
+		case *ast.ParamExpansion:
+			if err := expandParam(sh, f, p, quoted, stdin, stderr); err != nil {
+				return err
+			}
+		case *ast.CmdSubstitution:
+			cmd := p.Cmd
+			if p.Backtick {
+				cmd = unescapeBacktick(cmd, quoted)
+			}
+			out, err := sh.commandSubstitution(cmd, stdin, stderr)
+			if err != nil {
+				return err
+			}
+			f.writeExpansion(out, quoted)
+		case *ast.ArithExpansion:
+			// The expression is expanded as if double quoted.
+			expr := &fieldsBuilder{noSplit: true}
+			if err := expandParts(sh, expr, p.Expr, true, stdin, stderr); err != nil {
+				return err
+			}
+			v, err := arithExpansion(sh, expr.cur.String())
+			if err != nil {
+				return err
+			}
+			f.writeExpansion(strconv.FormatInt(v, 10), quoted)
+		default:
+			panic(fmt.Errorf("unsupported word part %T", p))
+		}
+	}
+	return nil
+}
+
+// unescapeBacktick removes the backslashes from the given `cmd` command substitution,
+// where they only quote '$', '`', '\' and, within double quotes, '"'.
+func unescapeBacktick(cmd string, quoted bool) string {
+	escapable := "$`\\"
+	if quoted {
+		escapable += `"`
+	}
+	var b strings.Builder
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] == '\\' && i+1 < len(cmd) && strings.IndexByte(escapable, cmd[i+1]) != -1 {
+			i++
+		}
+		b.WriteByte(cmd[i])
+	}
+	return b.String()
+}
+
+// expandParam writes the value of the given parameter to the fields builder.
+// quoted is set within double quotes.
+func expandParam(sh *Shell, f *fieldsBuilder, p *ast.ParamExpansion, quoted bool, stdin io.Reader, stderr io.Writer) error {
+	if p.Length {
+		v, err := lookupSetParam(sh, p.Name)
+		if err != nil {
+			return err
+		}
+		if p.Name == "@" || p.Name == "*" {
+			v = strings.Repeat(" ", len(sh.args))
+		}
+		f.writeExpansion(strconv.Itoa(utf8.RuneCountInString(v)), quoted)
+		return nil
+	}
+	if p.Op != "" {
+		return expandParamOp(sh, f, p, quoted, stdin, stderr)
+	}
+
+	switch p.Name {
+	case "@":
+		// "$@" expands to one field per positional parameter.
+		for i, arg := range sh.args {
+			if i > 0 {
+				f.separate()
+			}
+			f.writeExpansion(arg, quoted)
+		}
+	case "*":
+		if !quoted {
+			for i, arg := range sh.args {
+				if i > 0 {
+					f.separate()
+				}
+				f.writeFields(arg)
+			}
+			return nil
+		}
+		// "$*" expands to a single field joined by the first character of IFS.
+		sep := ""
+		if ifs := sh.ifs(); ifs != "" {
+			_, n := utf8.DecodeRuneInString(ifs)
+			sep = ifs[:n]
+		}
+		f.write(strings.Join(sh.args, sep), true)
+	default:
+		v, err := lookupSetParam(sh, p.Name)
+		if err != nil {
+			return err
+		}
+		f.writeExpansion(v, quoted)
+	}
+	return nil
+}
+
+// expandParamOp handles the ${name<op>word} forms.
+// Within double quotes, the word is too, unless a pattern.
+func expandParamOp(sh *Shell, f *fieldsBuilder, p *ast.ParamExpansion, quoted bool, stdin io.Reader, stderr io.Writer) error {
+	v, set := lookupParam(sh, p.Name)
+	// With a colon, the null value is considered as unset.
+	useWord := !set || (strings.HasPrefix(p.Op, ":") && v == "")
+	// expandOperand expands the word into a single string.
+	expandOperand := func() (string, error) {
+		operand := &fieldsBuilder{noSplit: true}
+		if err := expandParts(sh, operand, expandTilde(sh, p.Word), quoted, stdin, stderr); err != nil {
+			return "", err
+		}
+		return operand.cur.String(), nil
+	}
+
+	// expandSplit expands the word in place, its unquoted literals being split too.
+	expandSplit := func() error {
+		splitLiterals := f.splitLiterals
+		f.splitLiterals = !quoted
+		defer func() { f.splitLiterals = splitLiterals }()
+		return expandParts(sh, f, p.Word, quoted, stdin, stderr)
+	}
+
+	switch strings.TrimPrefix(p.Op, ":") {
+	case "-":
+		if useWord {
+			return expandSplit()
+		}
+	case "=":
+		if useWord {
+			if !isName(p.Name) {
+				return fmt.Errorf("$%s: cannot assign in this way", p.Name)
+			}
+			value, err := expandOperand()
+			if err != nil {
+				return err
+			}
+			if err := sh.setVar(p.Name, value); err != nil {
+				return &ExitError{Code: 1, Err: err}
+			}
+			v = value
+		}
+	case "?":
+		if useWord {
+			msg, err := expandOperand()
+			if err != nil {
+				return err
+			}
+			if msg == "" {
+				msg = "parameter null or not set"
+				if set {
+					msg = "parameter null"
+				}
+			}
+			return &ExitError{Code: 1, Err: fmt.Errorf("%s: %s", p.Name, msg)}
+		}
+	case "+":
+		if !useWord {
+			return expandSplit()
+		}
+		v = ""
+	case "#", "##", "%", "%%":
+		if _, err := lookupSetParam(sh, p.Name); err != nil {
+			return err
+		}
+		pattern, err := expandPattern(sh, p.Word, stdin, stderr)
+		if err != nil {
+			return err
+		}
+		v = trimPattern(v, pattern, p.Op)
+	default:
+		return fmt.Errorf("unsupported parameter expansion operator %q", p.Op)
+	}
+	f.writeExpansion(v, quoted)
+	return nil
+}
+
+// trimPattern removes the smallest (# and %) or largest (## and %%)
+// prefix (# and ##) or suffix (% and %%) matching the pattern from the given value.
+func trimPattern(value, pattern, op string) string {
+	// Candidate cut positions, on rune boundaries.
+	idx := make([]int, 0, len(value)+1)
+	for i := range value {
+		idx = append(idx, i)
+	}
+	idx = append(idx, len(value))
+
+	switch op {
+	case "#":
+		for _, i := range idx {
+			if matchPattern(pattern, value[:i]) {
+				return value[i:]
+			}
+		}
+	case "##":
+		for j := len(idx) - 1; j >= 0; j-- {
+			if matchPattern(pattern, value[:idx[j]]) {
+				return value[idx[j]:]
+			}
+		}
+	case "%":
+		for j := len(idx) - 1; j >= 0; j-- {
+			if matchPattern(pattern, value[idx[j]:]) {
+				return value[:idx[j]]
+			}
+		}
+	case "%%":
+		for _, i := range idx {
+			if matchPattern(pattern, value[i:]) {
+				return value[:i]
+			}
+		}
+	}
+	return value
+}
+
+// isName returns true if the given string is a valid variable name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (i == 0 || !(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// ifs returns the value of IFS, defaulting to space, tab and newline when unset.
+func (sh *Shell) ifs() string {
+	ifs, ok := sh.getVar("IFS")
+	if !ok {
+		return " \t\n"
+	}
+	return ifs
+}
+
+// lookupSetParam returns the value of the given parameter.
+// With nounset, unset parameters other than $@ and $* are fatal errors.
+func lookupSetParam(sh *Shell, name string) (string, error) {
+	v, ok := lookupParam(sh, name)
+	if !ok && sh.opts["nounset"] && name != "@" && name != "*" {
+		return "", &ExitError{Code: 1, Err: fmt.Errorf("%s: parameter not set", name)}
+	}
+	return v, nil
+}
+
+// lookupParam returns the value of the given parameter and whether it is set.
+func lookupParam(sh *Shell, name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(sh.lastExitCode), true
+	case "$":
+		return strconv.Itoa(sh.pid), true
+	case "#":
+		return strconv.Itoa(len(sh.args)), true
+	case "0":
+		return sh.name, true
+	case "@", "*":
+		return strings.Join(sh.args, " "), len(sh.args) > 0
+	case "!":
+		if sh.lastAsyncPid == 0 {
+			return "", false
+		}
+		return strconv.Itoa(sh.lastAsyncPid), true
+	case "-":
+		return sh.optionFlags(), true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(sh.args) {
+			return "", false
+		}
+		return sh.args[n-1], true
+	}
+	return sh.getVar(name)
+}
@@ -0,0 +1,155 @@
+package executor
+
+import (
+	"io"
+	"maps"
+	"os"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// fdTable holds the descriptors of the shell itself, as changed by the redirections
+// of `exec` without command, inherited by all the subsequent commands.
+//
+// As the standard streams are threaded through the evaluation, their replacements
+// are keyed by the stream they replace, so the redirections of enclosing commands
+// still apply, i.e. `{ exec >foo; echo a; } >bar; echo b` only writes a to foo.
+type fdTable struct {
+	std   [3]map[any]any    // Replacements of stdin, stdout and stderr, by replaced stream.
+	files map[int]*os.File  // Descriptors above 2, nil once closed.
+	owned map[*os.File]bool // Files opened by exec, closed once no longer referenced.
+}
+
+func newFDTable() *fdTable {
+	return &fdTable{
+		std:   [3]map[any]any{{}, {}, {}},
+		files: map[int]*os.File{},
+		owned: map[*os.File]bool{},
+	}
+}
+
+// clone returns a copy of the table, not owning any file.
+func (t *fdTable) clone() *fdTable {
+	c := newFDTable()
+	for fd, m := range t.std {
+		maps.Copy(c.std[fd], m)
+	}
+	maps.Copy(c.files, t.files)
+	return c
+}
+
+// streams returns the given standard streams with the replacements made by exec, if any.
+func (t *fdTable) streams(stdin io.Reader, stdout, stderr io.Writer) (io.Reader, io.Writer, io.Writer) {
+	if r, ok := t.std[0][stdin]; ok {
+		stdin = r.(io.Reader)
+	}
+	if w, ok := t.std[1][stdout]; ok {
+		stdout = w.(io.Writer)
+	}
+	if w, ok := t.std[2][stderr]; ok {
+		stderr = w.(io.Writer)
+	}
+	return stdin, stdout, stderr
+}
+
+// extraFiles returns the descriptors above 2, as passed down to commands with exec.Cmd.ExtraFiles.
+func (t *fdTable) extraFiles() []*os.File {
+	if len(t.files) == 0 {
+		return nil
+	}
+	maxFD := 0
+	for n := range t.files {
+		maxFD = max(maxFD, n)
+	}
+	out := make([]*os.File, maxFD-3+1)
+	for n, f := range t.files {
+		out[n-3] = f
+	}
+	return out
+}
+
+// replaceStd replaces the given standard stream, by descriptor, with the given one,
+// along with the streams it already replaced.
+func (t *fdTable) replaceStd(fd int, from, to any) {
+	m := t.std[fd]
+	for k, v := range m {
+		if v == from {
+			m[k] = to
+		}
+	}
+	m[from] = to
+	for k, v := range m {
+		if k == v {
+			delete(m, k)
+		}
+	}
+	if f, ok := from.(*os.File); ok {
+		t.release(f)
+	}
+}
+
+// setFile sets the given descriptor above 2, nil to close it.
+func (t *fdTable) setFile(n int, f *os.File) {
+	old, ok := t.files[n]
+	t.files[n] = f
+	if ok && old != nil && old != f {
+		t.release(old)
+	}
+}
+
+// release closes the given file if opened by exec and no longer referenced.
+func (t *fdTable) release(f *os.File) {
+	if !t.owned[f] {
+		return
+	}
+	for _, m := range t.std {
+		for _, v := range m {
+			if v == any(f) {
+				return
+			}
+		}
+	}
+	for _, elem := range t.files {
+		if elem == f {
+			return
+		}
+	}
+	delete(t.owned, f)
+	_ = f.Close() // Best effort.
+}
+
+// execRedirect makes the redirections of `exec` without command, set up on the given command,
+// apply to the shell itself. stdin, stdout and stderr are the streams before the redirections.
+func (sh *Shell) execRedirect(aCmd ast.Command, cmd CmdIO, stdin io.Reader, stdout, stderr io.Writer) {
+	// Files opened by the redirections, rather than duplicated, belong to the shell.
+	opened := map[int]bool{}
+	for _, elem := range aCmd.IORedirects() {
+		opened[elem.Number] = elem.IOFile.Filename != nil
+	}
+	own := func(n int, v any) {
+		if f, ok := v.(*os.File); ok && f != nil && opened[n] {
+			sh.fds.owned[f] = true
+		}
+	}
+
+	streams := [3][2]any{{stdin, cmd.GetStdin()}, {stdout, cmd.GetStdout()}, {stderr, cmd.GetStderr()}}
+	for fd, s := range streams {
+		own(fd, s[1])
+	}
+	for n := range opened {
+		if n > 2 {
+			own(n, cmd.GetExtraFD(n))
+		}
+	}
+
+	for fd, s := range streams {
+		if s[0] != s[1] {
+			sh.fds.replaceStd(fd, s[0], s[1])
+		}
+	}
+	for n := range opened {
+		if n > 2 {
+			sh.fds.setFile(n, cmd.GetExtraFD(n))
+		}
+	}
+}
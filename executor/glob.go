@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// expandPathname returns the pathnames matching the given pattern, sorted, or nil if none.
+// Each component of the path is matched separately against the directory entries,
+// a leading '.' only being matched explicitly.
+func expandPathname(pattern string) []string {
+	paths := []string{""}
+	for i, comp := range strings.Split(pattern, "/") {
+		var next []string
+		for _, path := range paths {
+			if i > 0 {
+				path += "/"
+			}
+			if !hasPattern(comp) {
+				next = append(next, path+unescapePattern(comp))
+				continue
+			}
+			dir := path
+			if dir == "" {
+				dir = "."
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(comp, ".") && !strings.HasPrefix(comp, `\.`) {
+					continue
+				}
+				if matchPattern(comp, name) {
+					next = append(next, path+name)
+				}
+			}
+		}
+		paths = next
+	}
+
+	// Components without pattern characters are not looked up, make sure the pathnames exist.
+	paths = slices.DeleteFunc(paths, func(path string) bool {
+		_, err := os.Lstat(path)
+		return err != nil
+	})
+	slices.Sort(paths)
+	return paths
+}
+
+// unescapePattern removes the backslashes escaping the characters of the given pattern.
+func unescapePattern(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
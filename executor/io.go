@@ -5,22 +5,54 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"syscall"
 
 	"go.creack.net/gosh2/ast"
 	"go.creack.net/gosh2/lexer"
 )
 
-func setupCommandIO(aCmd ast.Command, cmd CmdIO) error {
+// setupCommandIO sets up the redirections of the given command.
+// It returns the files it opened, for the caller to close once the command is done.
+// Failed redirections are reported on the stderr of the command, as redirected so far,
+// and returned as redirectError, while expansion errors are returned as is.
+func setupCommandIO(sh *Shell, aCmd ast.Command, cmd CmdIO) (opened []*os.File, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) {
+			if stderr := cmd.GetStderr(); stderr != nil {
+				fmt.Fprintf(stderr, "gosh2: %s\n", err)
+			}
+			err = &redirectError{err: err}
+		}
+		closeFiles(opened)
+		opened = nil
+	}()
 	for _, elem := range aCmd.IORedirects() {
 		var openFlags int
 		var in io.Reader
 		var out io.Writer
+		var filename string
+		if elem.IOFile.Filename != nil {
+			if filename, err = expandWordString(sh, elem.IOFile.Filename, cmd.GetStdin(), cmd.GetStderr()); err != nil {
+				return nil, err
+			}
+		}
 
 		switch elem.IOFile.Operator {
 		case lexer.TokRedirectLess:
 			openFlags |= os.O_RDONLY
 		case lexer.TokRedirectGreat, lexer.TokRedirectGreatAnd:
 			openFlags |= os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+			if sh.opts["noclobber"] && !isOtherFile(filename) {
+				// With noclobber, existing regular files are not overwritten.
+				openFlags |= os.O_EXCL
+			}
+		case lexer.TokRedirectClobber:
+			openFlags |= os.O_CREATE | os.O_TRUNC | os.O_WRONLY
 		case lexer.TokRedirectDoubleGreat:
 			openFlags |= os.O_CREATE | os.O_APPEND | os.O_WRONLY
 		case lexer.TokRedirectLessGreat:
@@ -29,28 +61,33 @@ func setupCommandIO(aCmd ast.Command, cmd CmdIO) error {
 		case lexer.TokRedirectDoubleLess:
 			r, w, err := os.Pipe()
 			if err != nil {
-				return fmt.Errorf("heredoc pipe: %w", err)
+				return nil, fmt.Errorf("heredoc pipe: %w", err)
 			}
+			opened = append(opened, r)
 			go func() {
-				defer func() { _ = w.Close() }()    // Best effort.
-				fmt.Fprint(w, elem.IOFile.Filename) // The content until HEREDOC is stored in Filename.
+				defer func() { _ = w.Close() }() // Best effort.
+				fmt.Fprint(w, filename)          // The content until HEREDOC is stored in Filename.
 			}()
 			in = r
 		default:
-			return fmt.Errorf("unsupported redirect %q", elem.IOFile.Operator)
+			return nil, fmt.Errorf("unsupported redirect %q", elem.IOFile.Operator)
 		}
 
-		if in == nil && elem.IOFile.Filename != "" {
+		if in == nil && elem.IOFile.Filename != nil {
 			// Check for invalid case `echo hello 4>& foo`.
 			// The `>&` redirect only support '1' (or empty, which defaults to 1)
 			// when used with a target filename.
 			if elem.IOFile.Operator == lexer.TokRedirectGreatAnd && elem.Number != 1 {
-				return fmt.Errorf("ambiguous redirect %q", elem.IOFile.Operator)
+				return nil, fmt.Errorf("ambiguous redirect %q", elem.IOFile.Operator)
+			}
+			f, err := os.OpenFile(filename, openFlags, 0o644)
+			if err != nil && openFlags&os.O_EXCL != 0 && errors.Is(err, os.ErrExist) {
+				return nil, fmt.Errorf("%s: cannot overwrite existing file", filename)
 			}
-			f, err := os.OpenFile(elem.IOFile.Filename, openFlags, 0o644)
 			if err != nil {
-				return fmt.Errorf("openfile %q: %w", elem.IOFile.Filename, err)
+				return nil, fmt.Errorf("openfile %q: %w", filename, err)
 			}
+			opened = append(opened, f)
 			if elem.Number == 0 {
 				in = f
 			} else {
@@ -68,16 +105,31 @@ func setupCommandIO(aCmd ast.Command, cmd CmdIO) error {
 			case 2:
 				out = cmd.GetStderr()
 			default:
-				out = cmd.GetExtraFD(*elem.IOFile.ToNumber)
-				if _, err := out.(*os.File).Stat(); err != nil {
-					return fmt.Errorf("%d: %w", *elem.IOFile.ToNumber, errors.Unwrap(err))
+				f := cmd.GetExtraFD(*elem.IOFile.ToNumber)
+				if f == nil {
+					return nil, fmt.Errorf("%d: %w", *elem.IOFile.ToNumber, syscall.EBADF)
+				}
+				if _, err := f.Stat(); err != nil {
+					return nil, fmt.Errorf("%d: %w", *elem.IOFile.ToNumber, errors.Unwrap(err))
 				}
+				in, out = f, f
 			}
 			if in == nil && out == nil {
-				return fmt.Errorf("bad file descriptor2 %d\n", *elem.IOFile.ToNumber)
+				return nil, fmt.Errorf("bad file descriptor2 %d\n", *elem.IOFile.ToNumber)
+			}
+		} else if elem.IOFile.Close {
+			if elem.Number > 2 {
+				// Descriptors not passed down are closed in the command.
+				cmd.SetExtraFD(elem.Number, nil)
+				continue
+			}
+			if elem.Number == 0 {
+				in = closedStream{}
+			} else {
+				out = closedStream{}
 			}
 		} else if in == nil && out == nil {
-			return fmt.Errorf("missing filename or fd for %q", elem.IOFile.Operator)
+			return nil, fmt.Errorf("missing filename or fd for %q", elem.IOFile.Operator)
 		}
 
 		switch elem.Number {
@@ -88,7 +140,7 @@ func setupCommandIO(aCmd ast.Command, cmd CmdIO) error {
 			cmd.SetStdout(out)
 
 			// Case for `>& filename`, redirect both stdout and stderr to the file.
-			if elem.IOFile.Operator == lexer.TokRedirectGreatAnd && elem.IOFile.Filename != "" {
+			if elem.IOFile.Operator == lexer.TokRedirectGreatAnd && elem.IOFile.Filename != nil {
 				cmd.SetStderr(out)
 			}
 		case 2:
@@ -96,10 +148,55 @@ func setupCommandIO(aCmd ast.Command, cmd CmdIO) error {
 		default:
 			f, ok := out.(*os.File)
 			if !ok {
-				return fmt.Errorf("unsupported file descriptor %d for %q: not a file (%T)", elem.Number, elem.IOFile.Operator, out)
+				return nil, fmt.Errorf("unsupported file descriptor %d for %q: not a file (%T)", elem.Number, elem.IOFile.Operator, out)
 			}
 			cmd.SetExtraFD(elem.Number, f)
 		}
 	}
-	return nil
+	return opened, nil
+}
+
+// closeFiles closes the given files, as opened by setupCommandIO.
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close() // Best effort.
+	}
+}
+
+// isOtherFile returns true if the given file exists and is not a regular file,
+// e.g. /dev/null, which noclobber still allows to write to.
+func isOtherFile(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && !fi.Mode().IsRegular()
+}
+
+// closedStream stands for a closed standard descriptor, i.e. `2>&-`.
+// Reading or writing it fails with EBADF within the shell, and the descriptor
+// is closed in the processes, see setClosedStreams.
+type closedStream struct{}
+
+func (closedStream) Read([]byte) (int, error)  { return 0, syscall.EBADF }
+func (closedStream) Write([]byte) (int, error) { return 0, syscall.EBADF }
+
+// isFileStream returns true if the given stream is a file, or stands for a closed descriptor.
+func isFileStream(v any) bool {
+	switch v.(type) {
+	case *os.File, closedStream:
+		return true
+	}
+	return false
+}
+
+// setClosedStreams replaces the closed standard streams of the given command with nil files,
+// which os.StartProcess closes in the process rather than inheriting them.
+func setClosedStreams(cmd *exec.Cmd) {
+	if _, ok := cmd.Stdin.(closedStream); ok {
+		cmd.Stdin = (*os.File)(nil)
+	}
+	if _, ok := cmd.Stdout.(closedStream); ok {
+		cmd.Stdout = (*os.File)(nil)
+	}
+	if _, ok := cmd.Stderr.(closedStream); ok {
+		cmd.Stderr = (*os.File)(nil)
+	}
 }
@@ -0,0 +1,126 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// SetInteractive makes the shell interactive, surviving the interrupts and with job control,
+// when the given input, where the commands are read from, is a terminal.
+func (sh *Shell) SetInteractive(input io.Reader) error {
+	f, ok := input.(*os.File)
+	if !ok || !isTerminal(f.Fd()) {
+		return nil
+	}
+	sh.interactive = true
+	signal.Notify(sh.signals, interactiveSignals...)
+	sh.opts["monitor"] = true
+	return sh.enableJobControl()
+}
+
+// enableJobControl puts the shell in its own process group, in the foreground of
+// its controlling terminal, so that each job can get its own process group.
+func (sh *Shell) enableJobControl() error {
+	if sh.tty != nil {
+		return nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("no job control: %w", err)
+	}
+
+	// The shell handles the job control signals rather than being stopped by them.
+	// Unlike ignored signals, handled ones are reset to their default in the commands.
+	sh.jobSignals = make(chan os.Signal, 1)
+	signal.Notify(sh.jobSignals, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	sh.sigchld = make(chan os.Signal, 1)
+	signal.Notify(sh.sigchld, syscall.SIGCHLD)
+
+	sh.tty, sh.pgid = tty, syscall.Getpid()
+	if syscall.Getpgrp() != sh.pgid {
+		if err := syscall.Setpgid(0, 0); err != nil {
+			sh.disableJobControl()
+			return fmt.Errorf("no job control: setpgid: %w", err)
+		}
+	}
+	if err := sh.setForeground(sh.pgid); err != nil {
+		sh.disableJobControl()
+		return fmt.Errorf("no job control: %w", err)
+	}
+	return nil
+}
+
+// disableJobControl releases the terminal and restores the job control signals.
+func (sh *Shell) disableJobControl() {
+	if sh.tty == nil {
+		return
+	}
+	signal.Stop(sh.jobSignals)
+	signal.Stop(sh.sigchld)
+	signal.Reset(syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+	_ = sh.tty.Close() // Best effort.
+	sh.tty, sh.pgid = nil, 0
+}
+
+// setForeground gives the terminal to the given process group.
+func (sh *Shell) setForeground(pgid int) error {
+	// Changing the foreground process group from the background raises SIGTTOU,
+	// which must be ignored for the shell to get the terminal back.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Notify(sh.jobSignals, syscall.SIGTTOU)
+	if err := tcsetpgrp(sh.tty.Fd(), pgid); err != nil {
+		return fmt.Errorf("tcsetpgrp: %w", err)
+	}
+	return nil
+}
+
+// setProcessGroup puts the given command in the given process group, or in a new one leading
+// the job in the foreground when pgid is 0. Commands running within the shell are left as is.
+// Returns the process to get the process group from once started, nil if none.
+func (sh *Shell) setProcessGroup(cmd CmdIO, pgid int) *exec.Cmd {
+	var c *exec.Cmd
+	switch cmd := cmd.(type) {
+	case *CmdWrap:
+		c = cmd.Cmd
+	case *execCmd:
+		c = cmd.Cmd
+	default:
+		return nil
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pgid:       pgid,
+		Foreground: pgid == 0,
+		Ctty:       int(sh.tty.Fd()), // Descriptor in the shell for Foreground.
+	}
+	return c
+}
+
+// waitForeground waits for the given job in the foreground until it is done or stopped,
+// then gets the terminal back. Returns true if the job is stopped.
+func (sh *Shell) waitForeground(j *job) bool {
+	defer func() { _ = sh.setForeground(sh.pgid) }() // Best effort.
+	for {
+		select {
+		case <-j.done:
+			return false
+		case <-sh.sigchld:
+			if isStopped(j.pgid) {
+				j.stopped = true
+				return true
+			}
+		}
+	}
+}
+
+// stopJob registers the given stopped job as the current job and reports it.
+// Returns the exit status of stopped jobs.
+func (sh *Shell) stopJob(j *job, stderr io.Writer) int {
+	sh.addJob(j)
+	fmt.Fprintf(stderr, "\n%s\n", sh.formatJob(j, false))
+	return 128 + int(syscall.SIGTSTP)
+}
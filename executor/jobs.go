@@ -0,0 +1,410 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// job is a pipeline running in the background, or stopped, identified by its job number.
+type job struct {
+	id   int    // Job number, %n.
+	pgid int    // Process group, 0 without job control.
+	pid  int    // Process id of the last process, used as $!.
+	text string // Command, as listed by jobs.
+
+	stopped bool
+
+	done     chan struct{} // Closed once all the processes are reaped.
+	exitCode int           // Exit status, set before done is closed.
+}
+
+// isDone returns true if all the processes of the job are reaped.
+func (j *job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// state returns the state of the job, as reported by jobs.
+func (j *job) state() string {
+	switch {
+	case j.isDone() && j.exitCode == 0:
+		return "Done"
+	case j.isDone():
+		return fmt.Sprintf("Done(%d)", j.exitCode)
+	case j.stopped:
+		return "Stopped"
+	default:
+		return "Running"
+	}
+}
+
+// exitStatus returns the shell exit status of the given process state,
+// 128 plus the signal number if the process was killed.
+func exitStatus(ps Exiter) int {
+	if ps, ok := ps.(*os.ProcessState); ok && ps != nil {
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+	}
+	return ps.ExitCode()
+}
+
+// addJob registers the given job, making it the current job.
+// New jobs get the next job number.
+func (sh *Shell) addJob(j *job) {
+	if j.id == 0 {
+		j.id = 1
+		for _, elem := range sh.jobs {
+			j.id = max(j.id, elem.id+1)
+		}
+	}
+	sh.jobs = append(sh.jobs, j)
+}
+
+// removeJob forgets about the given job.
+func (sh *Shell) removeJob(j *job) {
+	sh.jobs = slices.DeleteFunc(sh.jobs, func(elem *job) bool { return elem == j })
+}
+
+// currentMark returns the mark of the given job, '+' for the current job,
+// i.e. the most recent one, '-' for the previous one and ' ' otherwise.
+func (sh *Shell) currentMark(j *job) byte {
+	switch idx := slices.Index(sh.jobs, j); {
+	case idx == len(sh.jobs)-1:
+		return '+'
+	case idx == len(sh.jobs)-2:
+		return '-'
+	default:
+		return ' '
+	}
+}
+
+// formatJob formats the given job as reported by jobs, with its process id when long is set.
+func (sh *Shell) formatJob(j *job, long bool) string {
+	text := j.text
+	if !j.isDone() && !j.stopped {
+		text += " &"
+	}
+	if long {
+		return fmt.Sprintf("[%d]%c %d %-24s%s", j.id, sh.currentMark(j), j.pid, j.state(), text)
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s", j.id, sh.currentMark(j), j.state(), text)
+}
+
+// lookupJob returns the job referred to by the given job spec:
+// %n for job number n, %+ or %% for the current job, %- for the previous one,
+// %str for the job starting with str and %?str for the one containing str.
+// Without %, the spec is the process id of the job.
+func (sh *Shell) lookupJob(spec string) (*job, error) {
+	if !strings.HasPrefix(spec, "%") {
+		pid, err := strconv.Atoi(spec)
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("`%s': not a pid or valid job spec", spec)
+		}
+		idx := slices.IndexFunc(sh.jobs, func(j *job) bool { return j.pid == pid })
+		if idx == -1 {
+			return nil, fmt.Errorf("pid %d is not a child of this shell", pid)
+		}
+		return sh.jobs[idx], nil
+	}
+
+	var match func(j *job) bool
+	switch s := spec[1:]; {
+	case s == "" || s == "+" || s == "%":
+		if len(sh.jobs) > 0 {
+			return sh.jobs[len(sh.jobs)-1], nil
+		}
+	case s == "-":
+		if len(sh.jobs) > 1 {
+			return sh.jobs[len(sh.jobs)-2], nil
+		}
+	case s[0] >= '0' && s[0] <= '9':
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: no such job", spec)
+		}
+		match = func(j *job) bool { return j.id == n }
+	case s[0] == '?':
+		match = func(j *job) bool { return strings.Contains(j.text, s[1:]) }
+	default:
+		match = func(j *job) bool { return strings.HasPrefix(j.text, s) }
+	}
+	if match != nil {
+		var found *job
+		for _, j := range sh.jobs {
+			if !match(j) {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = j
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// updateJobs marks the running jobs stopped since the last check, i.e. by SIGTTIN.
+func (sh *Shell) updateJobs() {
+	for _, j := range sh.jobs {
+		if j.pgid != 0 && !j.stopped && !j.isDone() && isStopped(j.pgid) {
+			j.stopped = true
+		}
+	}
+}
+
+// notifyJobs reports and forgets the jobs done since the last command, with job control.
+func (sh *Shell) notifyJobs(w io.Writer) {
+	if sh.tty == nil {
+		return
+	}
+	sh.updateJobs()
+	for _, j := range slices.Clone(sh.jobs) {
+		if j.isDone() {
+			fmt.Fprintln(w, sh.formatJob(j, false))
+			sh.removeJob(j)
+		}
+	}
+}
+
+// evaluateAsync starts the given and-or list in the background, in a subshell,
+// and sets $! to its process id.
+func evaluateAsync(sh *Shell, andOr *ast.AndOr, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	stdin, stdout, stderr = sh.fds.streams(stdin, stdout, stderr)
+	cmd := sh.subshellCommand(asyncScript(sh, andOr))
+	// Without job control, the input of asynchronous lists is /dev/null.
+	if sh.opts["monitor"] {
+		cmd.Stdin = stdin
+	}
+	// With job control, the job gets its own process group, in the background.
+	if sh.tty != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setClosedStreams(cmd)
+	if err := cmd.Start(); err != nil {
+		return 1, fmt.Errorf("start async list %q: %w", andOr.Dump(), err)
+	}
+
+	j := &job{pid: cmd.Process.Pid, text: andOr.Dump(), done: make(chan struct{})}
+	if sh.tty != nil {
+		j.pgid = j.pid
+	}
+	go func() {
+		defer close(j.done)
+		_ = cmd.Wait() // The status is reported through the process state.
+		j.exitCode = exitStatus(cmd.ProcessState)
+	}()
+	sh.addJob(j)
+	if sh.opts["monitor"] && sh.tty != nil {
+		fmt.Fprintf(stderr, "[%d] %d\n", j.id, j.pid)
+	}
+	sh.lastAsyncPid = j.pid
+	sh.lastExitCode = 0
+	return 0, nil
+}
+
+// asyncScript returns the script run by the subshell of the given asynchronous and-or list.
+// A lone external command replaces the subshell with exec, for $! to be its process id.
+func asyncScript(sh *Shell, andOr *ast.AndOr) string {
+	if andOr.Left != nil || andOr.Right.Negated || andOr.Right.Right.Left != nil {
+		return andOr.Dump()
+	}
+	scmd, ok := andOr.Right.Right.Right.(*ast.SimpleCommand)
+	if !ok || (scmd.Prefix != nil && len(scmd.Prefix.AssignmentWords()) > 0) {
+		return andOr.Dump()
+	}
+	name, ok := scmd.Name.Lit()
+	if !ok || name == "" || name == "exec" || sh.funcs[name] != nil || specialBuiltins[name] != nil || regularBuiltins[name] != nil {
+		return andOr.Dump()
+	}
+	script := "exec " + scmd.Name.Dump()
+	if scmd.Prefix != nil {
+		script = scmd.Prefix.Dump() + " " + script
+	}
+	if scmd.Suffix != nil {
+		script += " " + scmd.Suffix.Dump()
+	}
+	return script
+}
+
+// builtinWait waits for the given process ids or job specs, or all the background jobs
+// without argument. The exit code is the one of the last job waited for, 127 if unknown,
+// or 128 plus the signal number when interrupted by a trapped signal.
+func builtinWait(sh *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, j := range slices.Clone(sh.jobs) {
+			if sig, ok := sh.waitJob(j); !ok {
+				return 128 + int(sig), nil
+			}
+			sh.removeJob(j)
+		}
+		return 0, nil
+	}
+
+	exitCode := 0
+	for _, arg := range args {
+		if pid, err := strconv.Atoi(arg); (err != nil || pid <= 0) && !strings.HasPrefix(arg, "%") {
+			c.errorf("`%s': not a pid or valid job spec", arg)
+			return 1, nil
+		}
+		j, err := sh.lookupJob(arg)
+		if err != nil {
+			exitCode = 127
+			continue
+		}
+		if sig, ok := sh.waitJob(j); !ok {
+			return 128 + int(sig), nil
+		}
+		exitCode = j.exitCode
+		sh.removeJob(j)
+	}
+	return exitCode, nil
+}
+
+// waitJob waits for the given job to be done, unless a signal handled by the shell is received,
+// in which case its trap runs right after wait returns. Returns the signal and false if interrupted.
+func (sh *Shell) waitJob(j *job) (syscall.Signal, bool) {
+	select {
+	case <-j.done:
+		return 0, true
+	case sig := <-sh.signals:
+		sh.pendingSignals = append(sh.pendingSignals, sig)
+		return sig.(syscall.Signal), false
+	}
+}
+
+// builtinJobs lists the jobs, or the given ones, with their state.
+// -l adds the process ids and -p only lists them. Done jobs are then forgotten.
+func builtinJobs(sh *Shell, c *builtinCmd) (int, error) {
+	long, pidOnly := false, false
+	args := c.args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'l':
+				long = true
+			case 'p':
+				pidOnly = true
+			default:
+				c.errorf("-%c: invalid option", flag)
+				return 2, nil
+			}
+		}
+		args = args[1:]
+	}
+
+	sh.updateJobs()
+	jobs := slices.Clone(sh.jobs)
+	slices.SortFunc(jobs, func(a, b *job) int { return a.id - b.id })
+	exitCode := 0
+	if len(args) > 0 {
+		jobs = jobs[:0]
+		for _, arg := range args {
+			j, err := sh.lookupJob(arg)
+			if err != nil {
+				c.errorf("%s", err)
+				exitCode = 1
+				continue
+			}
+			jobs = append(jobs, j)
+		}
+	}
+	for _, j := range jobs {
+		if pidOnly {
+			fmt.Fprintln(c.stdout, j.pid)
+		} else {
+			fmt.Fprintln(c.stdout, sh.formatJob(j, long))
+		}
+	}
+	for _, j := range jobs {
+		if j.isDone() {
+			sh.removeJob(j)
+		}
+	}
+	return exitCode, nil
+}
+
+// jobControlArg returns the job referred to by the given builtin arguments,
+// the current job by default. Errors are reported.
+func jobControlArg(sh *Shell, c *builtinCmd) (*job, bool) {
+	if sh.tty == nil {
+		c.errorf("no job control")
+		return nil, false
+	}
+	spec := "%+"
+	if args := c.args[1:]; len(args) > 0 {
+		spec = args[0]
+	}
+	j, err := sh.lookupJob(spec)
+	if err != nil {
+		if spec == "%+" {
+			err = fmt.Errorf("current: no such job")
+		}
+		c.errorf("%s", err)
+		return nil, false
+	}
+	return j, true
+}
+
+// builtinFg resumes the given job, the current one by default, in the foreground.
+// The exit code is the one of the job.
+func builtinFg(sh *Shell, c *builtinCmd) (int, error) {
+	j, ok := jobControlArg(sh, c)
+	if !ok {
+		return 1, nil
+	}
+	sh.removeJob(j)
+	if err := sh.setForeground(j.pgid); err != nil {
+		c.errorf("%s", err)
+	}
+	fmt.Fprintln(c.stdout, j.text)
+	j.stopped = false
+	_ = syscall.Kill(-j.pgid, syscall.SIGCONT) // Best effort, the job may be done already.
+	if sh.waitForeground(j) {
+		return sh.stopJob(j, c.stderr), nil
+	}
+	return j.exitCode, nil
+}
+
+// builtinBg resumes the given stopped job, the current one by default, in the background.
+func builtinBg(sh *Shell, c *builtinCmd) (int, error) {
+	j, ok := jobControlArg(sh, c)
+	if !ok {
+		return 1, nil
+	}
+	if !j.stopped {
+		c.errorf("job %d already in background", j.id)
+		return 0, nil
+	}
+	j.stopped = false
+	if err := syscall.Kill(-j.pgid, syscall.SIGCONT); err != nil {
+		c.errorf("%s", err)
+		return 1, nil
+	}
+	fmt.Fprintf(c.stdout, "[%d]%c %s &\n", j.id, sh.currentMark(j), j.text)
+	return 0, nil
+}
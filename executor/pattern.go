@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// patternChars are the characters with a special meaning in patterns.
+const patternChars = `*?[]\`
+
+// escapePattern escapes the special characters of the given string
+// so it matches literally when used in a pattern.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(patternChars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// hasPattern returns true if the given string has unescaped special pattern characters.
+func hasPattern(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether the whole string matches the given shell pattern.
+// Unlike filepath.Match, '*' and '?' also match '/'.
+//
+// Pattern syntax:
+//
+//	'*'         matches any sequence of characters
+//	'?'         matches any single character
+//	'[' ... ']' matches a single character from the set,
+//	            negated with '!' or '^', supporting ranges and classes like [:alpha:]
+//	'\' c       matches character c
+func matchPattern(pattern, s string) bool {
+	// Backtracking point for the last '*'.
+	starPattern, starStr := -1, -1
+
+	px, sx := 0, 0
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPattern, starStr = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					_, n := utf8.DecodeRuneInString(s[sx:])
+					px++
+					sx += n
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					r, n := utf8.DecodeRuneInString(s[sx:])
+					if matched, width, ok := matchBracket(pattern[px:], r); ok {
+						if matched {
+							px += width
+							sx += n
+							continue
+						}
+						break
+					}
+				}
+				// Unterminated bracket, '[' matches literally.
+				if sx < len(s) && s[sx] == '[' {
+					px++
+					sx++
+					continue
+				}
+			default:
+				if c == '\\' && px+1 < len(pattern) {
+					px++
+				}
+				pr, pn := utf8.DecodeRuneInString(pattern[px:])
+				if sx < len(s) {
+					if r, n := utf8.DecodeRuneInString(s[sx:]); r == pr {
+						px += pn
+						sx += n
+						continue
+					}
+				}
+			}
+		}
+		// Mismatch, backtrack to the last '*' and make it consume one more character.
+		if starPattern != -1 && starStr < len(s) {
+			_, n := utf8.DecodeRuneInString(s[starStr:])
+			starStr += n
+			px, sx = starPattern+1, starStr
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// charClasses maps the bracket character classes to their matching function.
+var charClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  unicode.IsDigit,
+	"graph":  func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// matchBracket matches the given rune against the bracket expression at the start of the pattern.
+// Returns whether it matched, the width of the bracket expression and false if the bracket is not terminated.
+func matchBracket(pattern string, r rune) (bool, int, bool) {
+	i := 1 // Skip '['.
+	negated := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negated = true
+		i++
+	}
+	matched := false
+	for first := true; i < len(pattern); first = false {
+		c := pattern[i]
+		// A ']' as first character is a literal.
+		if c == ']' && !first {
+			return matched != negated, i + 1, true
+		}
+		if c == '[' && i+1 < len(pattern) && pattern[i+1] == ':' {
+			if end := strings.Index(pattern[i+2:], ":]"); end != -1 {
+				if fn, ok := charClasses[pattern[i+2:i+2+end]]; ok {
+					if fn(r) {
+						matched = true
+					}
+					i += end + 4
+					continue
+				}
+			}
+		}
+		if c == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		lo, n := utf8.DecodeRuneInString(pattern[i:])
+		i += n
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			i++
+			if pattern[i] == '\\' && i+1 < len(pattern) {
+				i++
+			}
+			hi, n = utf8.DecodeRuneInString(pattern[i:])
+			i += n
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+	return false, 0, false
+}
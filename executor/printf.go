@@ -0,0 +1,285 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtinPrintf prints its arguments according to the format.
+// The format is reused as long as there are arguments left.
+func builtinPrintf(_ *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		c.errorf("usage: printf format [arguments]")
+		return 2, nil
+	}
+
+	p := &printfState{c: c, args: args[1:]}
+	var out strings.Builder
+	for {
+		start := p.pos
+		if stop := p.format(&out, args[0]); stop {
+			break
+		}
+		// Stop once all the arguments are consumed, or if the format doesn't consume any.
+		if p.pos >= len(p.args) || p.pos == start {
+			break
+		}
+	}
+	if _, err := io.WriteString(c.stdout, out.String()); err != nil {
+		c.errorf("write error: %s", err)
+		return 1, nil
+	}
+	return p.exitCode, nil
+}
+
+// printfState holds the arguments consumed by the printf format.
+type printfState struct {
+	c        *builtinCmd
+	args     []string
+	pos      int // Next argument.
+	exitCode int
+}
+
+// next returns the next argument, or an empty string if none is left.
+func (p *printfState) next() string {
+	if p.pos >= len(p.args) {
+		return ""
+	}
+	p.pos++
+	return p.args[p.pos-1]
+}
+
+// nextInt returns the next argument as an integer. A leading quote yields
+// the code of the following character. Invalid numbers are reported and yield 0.
+func (p *printfState) nextInt() int64 {
+	arg := p.next()
+	s := strings.TrimLeft(arg, " \t\n")
+	if s == "" {
+		return 0
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return int64(r)
+	}
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		p.c.errorf("%s: invalid number", arg)
+		p.exitCode = 1
+		return 0
+	}
+	return n
+}
+
+// nextFloat returns the next argument as a float.
+// Invalid numbers are reported and yield 0.
+func (p *printfState) nextFloat() float64 {
+	arg := p.next()
+	s := strings.TrimLeft(arg, " \t\n")
+	if s == "" {
+		return 0
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return float64(r)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.c.errorf("%s: invalid number", arg)
+		p.exitCode = 1
+		return 0
+	}
+	return f
+}
+
+// format writes the given format once to out, consuming the arguments.
+// Returns true if the output must stop, i.e. on \c or invalid directive.
+func (p *printfState) format(out *strings.Builder, format string) bool {
+	for i := 0; i < len(format); i++ {
+		switch c := format[i]; c {
+		case '\\':
+			s, n, stop := expandEscape(format[i:], false)
+			out.WriteString(s)
+			if stop {
+				return true
+			}
+			i += n - 1
+		case '%':
+			n, stop := p.directive(out, format[i:])
+			if stop {
+				return true
+			}
+			i += n - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return false
+}
+
+// directive writes the conversion at the start of the given format, starting with '%'.
+// Returns the length of the directive and true if the output must stop.
+func (p *printfState) directive(out *strings.Builder, format string) (int, bool) {
+	i := 1
+	if i < len(format) && format[i] == '%' {
+		out.WriteByte('%')
+		return 2, false
+	}
+
+	// Build the equivalent Go format.
+	spec := "%"
+	for i < len(format) && strings.IndexByte("-+ #0", format[i]) != -1 {
+		spec += format[i : i+1]
+		i++
+	}
+	if i < len(format) && format[i] == '*' {
+		spec += strconv.FormatInt(p.nextInt(), 10)
+		i++
+	} else {
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			spec += format[i : i+1]
+			i++
+		}
+	}
+	hasPrecision := false
+	if i < len(format) && format[i] == '.' {
+		hasPrecision = true
+		spec += "."
+		i++
+		if i < len(format) && format[i] == '*' {
+			spec += strconv.FormatInt(p.nextInt(), 10)
+			i++
+		} else {
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				spec += format[i : i+1]
+				i++
+			}
+		}
+	}
+	if i >= len(format) {
+		p.c.errorf("%s: missing format character", format)
+		p.exitCode = 1
+		return i, true
+	}
+
+	switch conv := format[i]; conv {
+	case 'd', 'i':
+		fmt.Fprintf(out, spec+"d", p.nextInt())
+	case 'o', 'u', 'x', 'X':
+		verb := string(conv)
+		if conv == 'u' {
+			verb = "d"
+		}
+		fmt.Fprintf(out, spec+verb, uint64(p.nextInt()))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		// Unlike Go, the default precision of %g is 6 like other conversions.
+		if !hasPrecision && (conv == 'g' || conv == 'G') {
+			spec += ".6"
+		}
+		fmt.Fprintf(out, spec+string(conv), p.nextFloat())
+	case 'c':
+		arg := p.next()
+		if arg != "" {
+			r, _ := utf8.DecodeRuneInString(arg)
+			arg = string(r)
+		}
+		fmt.Fprintf(out, spec+"s", arg)
+	case 's':
+		fmt.Fprintf(out, spec+"s", p.next())
+	case 'b':
+		s, stop := expandEscapes(p.next(), true)
+		fmt.Fprintf(out, spec+"s", s)
+		if stop {
+			return i + 1, true
+		}
+	default:
+		p.c.errorf("%%%c: invalid directive", conv)
+		p.exitCode = 1
+		return i + 1, true
+	}
+	return i + 1, false
+}
+
+// simpleEscapes maps the backslash escapes to their value.
+var simpleEscapes = map[byte]string{
+	'\\': "\\",
+	'a':  "\a",
+	'b':  "\b",
+	'e':  "\x1b",
+	'f':  "\f",
+	'n':  "\n",
+	'r':  "\r",
+	't':  "\t",
+	'v':  "\v",
+}
+
+// expandEscapes interprets the backslash escapes of the given string, as for printf %b
+// and echo -e when zeroOctal is set, where octal escapes are \0NNN.
+// Returns true if \c was found, in which case the output must stop.
+func expandEscapes(s string, zeroOctal bool) (string, bool) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		str, n, stop := expandEscape(s[i:], zeroOctal)
+		out.WriteString(str)
+		if stop {
+			return out.String(), true
+		}
+		i += n - 1
+	}
+	return out.String(), false
+}
+
+// expandEscape interprets the backslash escape at the start of the given string.
+// Octal escapes are \NNN, or \0NNN when zeroOctal is set.
+// Returns the value, the length of the escape and true for \c.
+func expandEscape(s string, zeroOctal bool) (string, int, bool) {
+	if len(s) < 2 {
+		return s, len(s), false
+	}
+	c := s[1]
+	if v, ok := simpleEscapes[c]; ok {
+		return v, 2, false
+	}
+	switch {
+	case c == 'c':
+		return "", 2, true
+	case c >= '0' && c <= '7':
+		start, maxLen := 1, 3
+		if zeroOctal && c == '0' {
+			start = 2
+		}
+		end := start
+		for end < len(s) && end-start < maxLen && s[end] >= '0' && s[end] <= '7' {
+			end++
+		}
+		n, _ := strconv.ParseUint(s[start:end], 8, 8)
+		if start == end {
+			n = 0
+		}
+		return string([]byte{byte(n)}), end, false
+	case c == 'x':
+		end := 2
+		for end < len(s) && end < 4 && strings.IndexByte("0123456789abcdefABCDEF", s[end]) != -1 {
+			end++
+		}
+		if end == 2 {
+			return s[:2], 2, false
+		}
+		n, _ := strconv.ParseUint(s[2:end], 16, 8)
+		return string([]byte{byte(n)}), end, false
+	case !zeroOctal && (c == '"' || c == '\''):
+		return s[1:2], 2, false
+	default:
+		return s[:2], 2, false
+	}
+}
@@ -0,0 +1,309 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// variable is a shell variable with its attributes.
+type variable struct {
+	value    string
+	set      bool // False when only declared with attributes, i.e. `export foo`.
+	exported bool // Exported variables are passed down to child processes.
+	readonly bool
+}
+
+// Shell holds the state of the shell execution environment.
+type Shell struct {
+	vars map[string]*variable // Shell variables.
+
+	name string   // Name of the shell or script, $0.
+	args []string // Positional parameters, $1, $2, etc.
+	pid  int      // Process id of the shell, $$, the one of the parent shell in subshells.
+
+	lastExitCode  int // Exit code of the last pipeline, $?.
+	substExitCode int // Exit code of the last command substitution, the one of commands without name.
+	lastAsyncPid  int // Process id of the last asynchronous list, $!, 0 if none.
+
+	jobs []*job // Background and stopped jobs, not yet waited for, the current one last.
+
+	fds     *fdTable // Descriptors of the shell, as changed by exec.
+	process bool     // True if the shell owns the process, i.e. its output is the process one, so exec can replace it.
+
+	// Job control, enabled in interactive shells or with `set -m`.
+	tty        *os.File       // Controlling terminal, nil without job control.
+	pgid       int            // Process group of the shell.
+	sigchld    chan os.Signal // Notified when a child stops or exits.
+	jobSignals chan os.Signal // Job control signals, handled by the shell.
+
+	opts  map[string]bool   // Shell options, by long name, set with `set -o`.
+	traps map[string]string // Trap actions, by condition name.
+
+	interactive    bool           // Interactive shells survive SIGINT, SIGQUIT and SIGTERM.
+	signals        chan os.Signal // Notified of the signals handled by the shell, i.e. trapped.
+	pendingSignals []os.Signal    // Signals received while waiting, with their trap still to run.
+	inTrap         bool           // True while running a trap action.
+
+	funcs map[string]*ast.CompoundCommandWrap // Function bodies, by name.
+
+	loopDepth int // Number of enclosing loops, for break and continue.
+	funcDepth int // Number of enclosing functions or sourced scripts, for return.
+	noErrexit int // Number of enclosing contexts where errexit is ignored, i.e. conditions.
+
+	newParser func(r io.Reader) Parser // Used to evaluate strings, i.e. eval and `.`.
+
+	syncOutputs map[io.Writer]*syncWriter // Shared wrappers of the outputs of the shell, when not files.
+}
+
+// NewShell creates a new shell state, initialized from the process environment.
+// newParser is used by the builtins evaluating strings, like eval and `.`.
+func NewShell(newParser func(r io.Reader) Parser) *Shell {
+	sh := &Shell{
+		vars:        map[string]*variable{},
+		name:        "gosh2",
+		pid:         os.Getpid(),
+		opts:        map[string]bool{},
+		traps:       map[string]string{},
+		signals:     make(chan os.Signal, 8),
+		funcs:       map[string]*ast.CompoundCommandWrap{},
+		fds:         newFDTable(),
+		syncOutputs: map[io.Writer]*syncWriter{},
+		newParser:   newParser,
+	}
+	for _, elem := range os.Environ() {
+		name, value, _ := strings.Cut(elem, "=")
+		if name == subshellStateVar {
+			continue
+		}
+		sh.vars[name] = &variable{value: value, set: true, exported: true}
+	}
+
+	// IFS is always reset to its default value.
+	_ = sh.setVar("IFS", " \t\n") // Best effort, only fails if readonly.
+	// PWD is reset to the current directory, unless it already refers to it.
+	_ = sh.setVar("PWD", sh.pwd()) // Best effort, only fails if readonly.
+	return sh
+}
+
+// getVar returns the value of the given variable and whether it is set.
+func (sh *Shell) getVar(name string) (string, bool) {
+	v, ok := sh.vars[name]
+	if !ok || !v.set {
+		return "", false
+	}
+	return v.value, true
+}
+
+// setVar sets the value of the given variable, keeping its attributes.
+func (sh *Shell) setVar(name, value string) error {
+	v, ok := sh.vars[name]
+	if !ok {
+		v = &variable{}
+		sh.vars[name] = v
+	}
+	if v.readonly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	v.value = value
+	v.set = true
+	if sh.opts["allexport"] {
+		v.exported = true
+	}
+	return nil
+}
+
+// unsetVar removes the given variable and its attributes.
+func (sh *Shell) unsetVar(name string) error {
+	if v, ok := sh.vars[name]; ok && v.readonly {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	delete(sh.vars, name)
+	return nil
+}
+
+// exportVar marks the given variable for export to child processes.
+func (sh *Shell) exportVar(name string) {
+	v, ok := sh.vars[name]
+	if !ok {
+		v = &variable{}
+		sh.vars[name] = v
+	}
+	v.exported = true
+}
+
+// readonlyVar marks the given variable as readonly.
+func (sh *Shell) readonlyVar(name string) {
+	v, ok := sh.vars[name]
+	if !ok {
+		v = &variable{}
+		sh.vars[name] = v
+	}
+	v.readonly = true
+}
+
+// clone returns a copy of the shell execution environment, as for a subshell, used by the
+// commands of multi-command pipelines run within the shell process, concurrently.
+// Job control and the traps are not inherited, and the copy can't be replaced by exec.
+func (sh *Shell) clone() *Shell {
+	c := *sh
+	c.vars = make(map[string]*variable, len(sh.vars))
+	for name, v := range sh.vars {
+		cv := *v
+		c.vars[name] = &cv
+	}
+	c.args = slices.Clone(sh.args)
+	c.jobs = slices.Clone(sh.jobs)
+	c.fds = sh.fds.clone()
+	c.process = false
+	c.tty = nil
+	c.opts = maps.Clone(sh.opts)
+	c.opts["monitor"] = false
+	c.traps = map[string]string{}
+	c.interactive = false
+	c.signals = make(chan os.Signal, 8)
+	c.pendingSignals = nil
+	c.inTrap = false
+	c.funcs = maps.Clone(sh.funcs)
+	return &c
+}
+
+// Option returns true if the given shell option, by long name, is set.
+func (sh *Shell) Option(name string) bool {
+	return sh.opts[name]
+}
+
+// syncOutput returns the given output of the shell, wrapped once in a syncWriter shared by
+// all the commands when not a file, as background jobs and builtins write to it concurrently.
+func (sh *Shell) syncOutput(w io.Writer) io.Writer {
+	if w == nil || isFileStream(w) {
+		return w
+	}
+	if _, ok := w.(*syncWriter); ok {
+		return w
+	}
+	if sw, ok := sh.syncOutputs[w]; ok {
+		return sw
+	}
+	sw := &syncWriter{w: w}
+	sh.syncOutputs[w] = sw
+	return sw
+}
+
+// environ returns the environment passed down to child processes,
+// i.e. the exported variables, in the "key=value" form.
+func (sh *Shell) environ() []string {
+	var out []string
+	for name, v := range sh.vars {
+		if v.exported && v.set {
+			out = append(out, name+"="+v.value)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// lookPath searches for the given executable in the directories
+// of the given PATH value. On failure, the file is returned as is.
+func lookPath(file, path string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, file)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() && fi.Mode()&0o111 != 0 {
+			return p, nil
+		}
+	}
+	return file, fmt.Errorf("%s: command not found", file)
+}
+
+// SubshellPrelude returns the shell code restoring the state not inherited
+// from the environment in a subshell, i.e. the non-exported variables,
+// the variable attributes, the functions, the options and the positional parameters.
+func (sh *Shell) SubshellPrelude() string {
+	var vars, attrs []string
+	for name, v := range sh.vars {
+		if !v.exported && v.set {
+			vars = append(vars, name+"="+quote(v.value))
+		}
+		if v.exported && !v.set {
+			attrs = append(attrs, "export "+name)
+		}
+		if v.readonly {
+			attrs = append(attrs, "readonly "+name)
+		}
+	}
+	slices.Sort(vars)
+	slices.Sort(attrs)
+	out := append(vars, attrs...)
+	for _, name := range slices.Sorted(maps.Keys(sh.funcs)) {
+		out = append(out, ast.FunctionDefinition{Name: name, Body: sh.funcs[name]}.Dump())
+	}
+	if len(sh.args) > 0 {
+		args := make([]string, 0, len(sh.args))
+		for _, arg := range sh.args {
+			args = append(args, quote(arg))
+		}
+		out = append(out, "set -- "+strings.Join(args, " "))
+	}
+	// Options come last, xtrace being the last one, for the prelude not to be traced.
+	for _, opt := range shellOptions {
+		// Job control is not inherited by subshells.
+		if sh.opts[opt.name] && opt.name != "monitor" {
+			out = append(out, "set -o "+opt.name)
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// subshellStateVar is the environment variable passing down to subshells the state
+// the prelude can't restore, i.e. $$, $?, $! and whether errexit is ignored, within conditions.
+// It is not a variable of the subshell.
+const subshellStateVar = "GOSH2_SUBSHELL"
+
+// subshellState returns the value of subshellStateVar for a subshell of the shell.
+func (sh *Shell) subshellState() string {
+	return fmt.Sprintf("%d %d %d %d", sh.pid, sh.lastExitCode, sh.lastAsyncPid, min(sh.noErrexit, 1))
+}
+
+// subshellCommand returns the command running the given script in a subshell,
+// i.e. a child process restoring the state of the shell with RestoreSubshell.
+func (sh *Shell) subshellCommand(script string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-sub", sh.SubshellPrelude(), "-c", script)
+	cmd.Env = append(sh.environ(), subshellStateVar+"="+sh.subshellState())
+	cmd.ExtraFiles = sh.fds.extraFiles()
+	return cmd
+}
+
+// RestoreSubshell restores the state of the parent shell in a subshell: the one set
+// by the given prelude, see SubshellPrelude, then the one passed down through the environment.
+func (sh *Shell) RestoreSubshell(prelude string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if _, err := sh.evalInput(strings.NewReader(prelude), stdin, stdout, stderr); err != nil {
+		return fmt.Errorf("subshell prelude: %w", err)
+	}
+	if state, ok := os.LookupEnv(subshellStateVar); ok {
+		if _, err := fmt.Sscanf(state, "%d %d %d %d", &sh.pid, &sh.lastExitCode, &sh.lastAsyncPid, &sh.noErrexit); err != nil {
+			return fmt.Errorf("subshell state %q: %w", state, err)
+		}
+	}
+	return nil
+}
+
+// quote returns the given string single quoted for the shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
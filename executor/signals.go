@@ -0,0 +1,284 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// interactiveSignals are the signals an interactive shell survives, unless trapped.
+var interactiveSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM}
+
+// trapSignal returns the signal of the given trap condition name, 0 for EXIT.
+func trapSignal(name string) syscall.Signal {
+	for _, elem := range trapConditions {
+		if elem.name == name {
+			return elem.sig
+		}
+	}
+	return 0
+}
+
+// signalName returns the trap condition name of the given signal.
+func signalName(sig os.Signal) string {
+	for _, elem := range trapConditions {
+		if elem.sig != 0 && elem.sig == sig {
+			return elem.name
+		}
+	}
+	return ""
+}
+
+// setTrap sets the action of the given trap condition, "-" resetting it, along with the signal
+// disposition. Signals are ignored with an empty action and handled by the shell otherwise.
+// Commands inherit the ignored signals while the handled ones are reset to their default.
+func (sh *Shell) setTrap(name, action string) {
+	if action == "-" {
+		delete(sh.traps, name)
+	} else {
+		sh.traps[name] = action
+	}
+
+	sig := trapSignal(name)
+	switch {
+	case sig == 0, sig == syscall.SIGKILL, sig == syscall.SIGSTOP:
+		// EXIT is not a signal and the others can't be caught.
+	case action == "-":
+		sh.resetSignal(sig)
+	case action == "" && sig != syscall.SIGCHLD:
+		// Ignoring SIGCHLD would prevent waiting for the commands.
+		signal.Ignore(sig)
+	default:
+		signal.Notify(sh.signals, sig)
+	}
+}
+
+// resetSignal restores the default disposition of the given signal,
+// or the shell one for the signals handled by interactive shells and job control.
+func (sh *Shell) resetSignal(sig syscall.Signal) {
+	signal.Reset(sig)
+	if sh.interactive {
+		for _, elem := range interactiveSignals {
+			if elem == sig {
+				signal.Notify(sh.signals, sig)
+			}
+		}
+	}
+	if sh.tty != nil {
+		switch sig {
+		case syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+			signal.Notify(sh.jobSignals, sig)
+		case syscall.SIGCHLD:
+			signal.Notify(sh.sigchld, sig)
+		}
+	}
+}
+
+// handlesSignal returns true if the given signal is notified on sh.signals,
+// i.e. trapped with an action or, in interactive shells, survived.
+func (sh *Shell) handlesSignal(sig syscall.Signal) bool {
+	if action, ok := sh.traps[signalName(sig)]; ok {
+		return action != "" || sig == syscall.SIGCHLD
+	}
+	return sh.interactive && slices.Contains(interactiveSignals, os.Signal(sig))
+}
+
+// awaitSignal waits for the delivery of the given signal, sent by the shell to itself,
+// which is asynchronous, for its trap to run right after the command that sent it.
+func (sh *Shell) awaitSignal(sig syscall.Signal) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case got := <-sh.signals:
+			sh.pendingSignals = append(sh.pendingSignals, got)
+			if got == sig {
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// builtinKill sends a signal, TERM by default, to the given process ids, negative for process groups,
+// or job specs. -l lists the signal names, or the one of the given exit status.
+// The handled signals sent to the shell itself are waited for, so their trap runs right after.
+func builtinKill(sh *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if len(args) > 0 && args[0] == "-l" {
+		return killList(c, args[1:]), nil
+	}
+	sig := syscall.SIGTERM
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && args[0] != "--" {
+		spec := args[0][1:]
+		args = args[1:]
+		if spec == "s" {
+			if len(args) == 0 {
+				c.errorf("-s: option requires an argument")
+				return 2, nil
+			}
+			spec, args = args[0], args[1:]
+		}
+		name := lookupTrapCondition(spec)
+		if name == "" {
+			c.errorf("%s: invalid signal specification", spec)
+			return 1, nil
+		}
+		sig = trapSignal(name)
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		c.errorf("usage: kill [-s sigspec | -sigspec] pid | jobspec ... or kill -l [exit_status]")
+		return 2, nil
+	}
+
+	exitCode := 0
+	self := false
+	for _, arg := range args {
+		pid, err := strconv.Atoi(arg)
+		if strings.HasPrefix(arg, "%") {
+			j, err2 := sh.lookupJob(arg)
+			if err2 != nil {
+				c.errorf("%s", err2)
+				exitCode = 1
+				continue
+			}
+			pid, err = j.pid, nil
+			if j.pgid != 0 {
+				pid = -j.pgid
+			}
+		}
+		if err != nil {
+			c.errorf("%s: arguments must be process or job IDs", arg)
+			exitCode = 1
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			c.errorf("(%d) - %s", pid, err)
+			exitCode = 1
+			continue
+		}
+		self = self || pid == os.Getpid() || pid == 0 || pid == -syscall.Getpgrp()
+	}
+	if self && sig != 0 && sh.handlesSignal(sig) {
+		sh.awaitSignal(sig)
+	}
+	return exitCode, nil
+}
+
+// killList lists the signal names, one per line, or the ones of the given
+// signal numbers or exit statuses of signaled commands.
+func killList(c *builtinCmd, args []string) int {
+	if len(args) == 0 {
+		for _, elem := range trapConditions[1:] {
+			fmt.Fprintln(c.stdout, elem.name)
+		}
+		return 0
+	}
+	exitCode := 0
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err == nil && n > 128 {
+			n -= 128
+		}
+		name := ""
+		if err == nil && n > 0 {
+			name = lookupTrapCondition(strconv.Itoa(n))
+		}
+		if name == "" {
+			c.errorf("%s: invalid signal specification", arg)
+			exitCode = 1
+			continue
+		}
+		fmt.Fprintln(c.stdout, name)
+	}
+	return exitCode
+}
+
+// runTraps runs the actions of the trapped signals received since the last check,
+// i.e. once the foreground command is done. $? is preserved.
+func (sh *Shell) runTraps(stdin io.Reader, stdout, stderr io.Writer) error {
+	// Traps don't interrupt each other.
+	if sh.inTrap {
+		return nil
+	}
+	for {
+		var sig os.Signal
+		if len(sh.pendingSignals) > 0 {
+			sig, sh.pendingSignals = sh.pendingSignals[0], sh.pendingSignals[1:]
+		} else {
+			select {
+			case sig = <-sh.signals:
+			default:
+				return nil
+			}
+		}
+		action := sh.traps[signalName(sig)]
+		if action == "" {
+			continue
+		}
+		exitCode := sh.lastExitCode
+		sh.inTrap = true
+		_, err := sh.evalInput(strings.NewReader(action), stdin, stdout, stderr)
+		sh.inTrap = false
+		sh.lastExitCode = exitCode
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Exit runs the EXIT trap, if any, once the shell is done with the given exit code,
+// and restores the signal dispositions. Returns the exit code of the shell,
+// which the trap can change with exit.
+func (sh *Shell) Exit(exitCode int, stdin io.Reader, stdout, stderr io.Writer) int {
+	defer func() {
+		for name := range sh.traps {
+			if sig := trapSignal(name); sig != 0 {
+				signal.Reset(sig)
+			}
+		}
+		signal.Stop(sh.signals)
+		sh.disableJobControl()
+	}()
+
+	// The traps of the signals received since the last command run first.
+	stdout, stderr = sh.syncOutput(stdout), sh.syncOutput(stderr)
+	sh.lastExitCode = exitCode
+	exitCode = trapExitCode(sh.runTraps(stdin, stdout, stderr), exitCode, stderr)
+
+	action, ok := sh.traps["EXIT"]
+	if !ok {
+		return exitCode
+	}
+	// The trap runs once, even if it exits.
+	delete(sh.traps, "EXIT")
+	sh.lastExitCode = exitCode
+	_, err := sh.evalInput(strings.NewReader(action), stdin, stdout, stderr)
+	return trapExitCode(err, exitCode, stderr)
+}
+
+// trapExitCode reports the given error of a trap action, if any, and returns the exit code
+// of the shell: the one of exit in the action, or the given one otherwise.
+func trapExitCode(err error, exitCode int, stderr io.Writer) int {
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		if exitErr.Err != nil {
+			fmt.Fprintf(stderr, "gosh2: %s\n", exitErr.Err)
+		}
+		return exitErr.Code
+	case err != nil:
+		fmt.Fprintf(stderr, "gosh2: %s\n", err)
+	}
+	return exitCode
+}
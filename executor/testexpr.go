@@ -0,0 +1,272 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// builtinTest evaluates the given conditional expression, succeeding if true.
+// When called as "[", the last argument must be "]".
+func builtinTest(_ *Shell, c *builtinCmd) (int, error) {
+	args := c.args[1:]
+	if c.args[0] == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			c.errorf("missing `]'")
+			return 2, nil
+		}
+		args = args[:len(args)-1]
+	}
+	ok, err := evalTest(args)
+	if err != nil {
+		c.errorf("%s", err)
+		return 2, nil
+	}
+	if !ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// unaryTestOps are the unary operators of test.
+var unaryTestOps = map[string]func(string) (bool, error){
+	"-b": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeDevice != 0 && fi.Mode()&os.ModeCharDevice == 0 }),
+	"-c": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeCharDevice != 0 }),
+	"-d": fileModeTest(func(fi os.FileInfo) bool { return fi.IsDir() }),
+	"-e": fileModeTest(func(os.FileInfo) bool { return true }),
+	"-f": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode().IsRegular() }),
+	"-g": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeSetgid != 0 }),
+	"-k": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeSticky != 0 }),
+	"-p": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeNamedPipe != 0 }),
+	"-S": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeSocket != 0 }),
+	"-s": fileModeTest(func(fi os.FileInfo) bool { return fi.Size() > 0 }),
+	"-u": fileModeTest(func(fi os.FileInfo) bool { return fi.Mode()&os.ModeSetuid != 0 }),
+	"-h": symlinkTest,
+	"-L": symlinkTest,
+	"-r": accessTest(4),
+	"-w": accessTest(2),
+	"-x": accessTest(1),
+	"-n": func(s string) (bool, error) { return s != "", nil },
+	"-z": func(s string) (bool, error) { return s == "", nil },
+	"-t": func(s string) (bool, error) {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", s)
+		}
+		return isTerminal(uintptr(fd)), nil
+	},
+}
+
+// fileModeTest returns a unary test checking the given file, following symlinks.
+func fileModeTest(fn func(os.FileInfo) bool) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		fi, err := os.Stat(name)
+		return err == nil && fn(fi), nil
+	}
+}
+
+// symlinkTest checks if the given file is a symbolic link.
+func symlinkTest(name string) (bool, error) {
+	fi, err := os.Lstat(name)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+}
+
+// accessTest returns a unary test checking the given file permission,
+// i.e. 4 for read, 2 for write and 1 for execute.
+func accessTest(mode uint32) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		return syscall.Access(name, mode) == nil, nil
+	}
+}
+
+// binaryTestOps are the binary operators of test.
+var binaryTestOps = map[string]func(string, string) (bool, error){
+	"=":   func(a, b string) (bool, error) { return a == b, nil },
+	"!=":  func(a, b string) (bool, error) { return a != b, nil },
+	"<":   func(a, b string) (bool, error) { return a < b, nil },
+	">":   func(a, b string) (bool, error) { return a > b, nil },
+	"-eq": intTest(func(a, b int64) bool { return a == b }),
+	"-ne": intTest(func(a, b int64) bool { return a != b }),
+	"-gt": intTest(func(a, b int64) bool { return a > b }),
+	"-ge": intTest(func(a, b int64) bool { return a >= b }),
+	"-lt": intTest(func(a, b int64) bool { return a < b }),
+	"-le": intTest(func(a, b int64) bool { return a <= b }),
+	"-ef": func(a, b string) (bool, error) {
+		fi1, err1 := os.Stat(a)
+		fi2, err2 := os.Stat(b)
+		return err1 == nil && err2 == nil && os.SameFile(fi1, fi2), nil
+	},
+	"-nt": func(a, b string) (bool, error) {
+		fi1, err1 := os.Stat(a)
+		fi2, err2 := os.Stat(b)
+		return err1 == nil && (err2 != nil || fi1.ModTime().After(fi2.ModTime())), nil
+	},
+	"-ot": func(a, b string) (bool, error) {
+		fi1, err1 := os.Stat(a)
+		fi2, err2 := os.Stat(b)
+		return err2 == nil && (err1 != nil || fi1.ModTime().Before(fi2.ModTime())), nil
+	},
+}
+
+// intTest returns a binary test comparing its operands as integers.
+func intTest(fn func(a, b int64) bool) func(string, string) (bool, error) {
+	return func(a, b string) (bool, error) {
+		x, err := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", a)
+		}
+		y, err := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", b)
+		}
+		return fn(x, y), nil
+	}
+}
+
+// evalTest evaluates the given test expression.
+// Up to 4 arguments, the POSIX rules based on the number of arguments apply,
+// otherwise, the expression is parsed with the -a, -o, ! and parentheses operators.
+func evalTest(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			return args[1] == "", nil
+		}
+		if op, ok := unaryTestOps[args[0]]; ok {
+			return op(args[1])
+		}
+		return false, fmt.Errorf("%s: unary operator expected", args[0])
+	case 3:
+		if op, ok := binaryTestOps[args[1]]; ok {
+			return op(args[0], args[2])
+		}
+		if args[0] == "!" {
+			ok, err := evalTest(args[1:])
+			return !ok, err
+		}
+		if args[0] == "(" && args[2] == ")" {
+			return args[1] != "", nil
+		}
+	case 4:
+		if args[0] == "!" {
+			ok, err := evalTest(args[1:])
+			return !ok, err
+		}
+		if args[0] == "(" && args[3] == ")" {
+			return evalTest(args[1:3])
+		}
+	}
+	p := &testParser{args: args}
+	ok, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.args) {
+		return false, fmt.Errorf("%s: unexpected argument", p.args[p.pos])
+	}
+	return ok, nil
+}
+
+// testParser is a recursive descent parser for the test expressions:
+//
+//	or      := and { "-o" and }
+//	and     := not { "-a" not }
+//	not     := "!" not | primary
+//	primary := "(" or ")" | unary-op operand | operand binary-op operand | operand
+type testParser struct {
+	args []string
+	pos  int
+}
+
+// peek returns the argument at the given offset from the current position, if any.
+func (p *testParser) peek(offset int) (string, bool) {
+	if p.pos+offset >= len(p.args) {
+		return "", false
+	}
+	return p.args[p.pos+offset], true
+}
+
+func (p *testParser) parseOr() (bool, error) {
+	ok, err := p.parseAnd()
+	if err != nil {
+		return false, err
+	}
+	for arg, _ := p.peek(0); arg == "-o"; arg, _ = p.peek(0) {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return false, err
+		}
+		ok = ok || right
+	}
+	return ok, nil
+}
+
+func (p *testParser) parseAnd() (bool, error) {
+	ok, err := p.parseNot()
+	if err != nil {
+		return false, err
+	}
+	for arg, _ := p.peek(0); arg == "-a"; arg, _ = p.peek(0) {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return false, err
+		}
+		ok = ok && right
+	}
+	return ok, nil
+}
+
+func (p *testParser) parseNot() (bool, error) {
+	if arg, _ := p.peek(0); arg == "!" {
+		if _, ok := p.peek(1); ok {
+			p.pos++
+			ok, err := p.parseNot()
+			return !ok, err
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *testParser) parsePrimary() (bool, error) {
+	arg, ok := p.peek(0)
+	if !ok {
+		return false, fmt.Errorf("argument expected")
+	}
+	// Binary operators take precedence so operands can look like operators, i.e. `-n = -n`.
+	if op, ok := p.peek(1); ok {
+		if fn, ok := binaryTestOps[op]; ok {
+			if right, ok := p.peek(2); ok {
+				p.pos += 3
+				return fn(arg, right)
+			}
+		}
+	}
+	if arg == "(" {
+		p.pos++
+		ok, err := p.parseOr()
+		if err != nil {
+			return false, err
+		}
+		if closing, _ := p.peek(0); closing != ")" {
+			return false, fmt.Errorf("`)' expected")
+		}
+		p.pos++
+		return ok, nil
+	}
+	if fn, ok := unaryTestOps[arg]; ok {
+		if operand, ok := p.peek(1); ok {
+			p.pos += 2
+			return fn(operand)
+		}
+	}
+	p.pos++
+	return arg != "", nil
+}
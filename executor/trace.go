@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// xtrace writes the given trace line to stderr, prefixed by the expanded PS4, when xtrace is set.
+func (sh *Shell) xtrace(stderr io.Writer, line string) {
+	if !sh.opts["xtrace"] {
+		return
+	}
+	ps4, ok := sh.getVar("PS4")
+	if !ok {
+		ps4 = "+ "
+	}
+	fmt.Fprintf(stderr, "%s%s\n", sh.expandPrompt(ps4), line)
+}
+
+// traceFields formats the given expanded fields of a command for the trace.
+func traceFields(fields []string) string {
+	quoted := make([]string, 0, len(fields))
+	for _, field := range fields {
+		quoted = append(quoted, traceQuote(field))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// traceAssignment formats the given assignment for the trace.
+func traceAssignment(name, value string) string {
+	return name + "=" + traceQuote(value)
+}
+
+// traceQuote quotes the given field for the trace if it is empty or contains special characters.
+func traceQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+		return s
+	}
+	return quote(s)
+}
+
+// expandPrompt expands the given prompt as a double quoted string.
+// The prompt is returned as is when it can't be parsed.
+func (sh *Shell) expandPrompt(prompt string) string {
+	if !strings.ContainsAny(prompt, "$`") {
+		return prompt
+	}
+	p := sh.newParser(strings.NewReader(`"` + strings.ReplaceAll(prompt, `"`, `\"`) + `"`))
+	cmd, err := nextCompleteCommand(p)
+	if err != nil || cmd == nil {
+		return prompt
+	}
+	scmd, ok := cmd.List.Right.Right.Right.Right.(*ast.SimpleCommand)
+	if !ok || scmd.Name == nil {
+		return prompt
+	}
+	value, err := expandWordString(sh, scmd.Name, nil, io.Discard)
+	if err != nil {
+		return prompt
+	}
+	return value
+}
@@ -0,0 +1,22 @@
+package executor
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal returns true if the given file descriptor refers to a terminal.
+func isTerminal(fd uintptr) bool {
+	var ws [4]uint16 // struct winsize.
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	return errno == 0
+}
+
+// tcsetpgrp makes the given process group the foreground one of the given terminal.
+func tcsetpgrp(fd uintptr, pgid int) error {
+	pgrp := int32(pgid) // pid_t.
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package executor
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isStopped returns true if a process of the given group stopped since the last check.
+// Only the stop notifications are consumed, exited processes are left to be reaped.
+func isStopped(pgid int) bool {
+	const pPGID = 2 // idtype_t P_PGID.
+	// si_pid follows si_signo, si_errno and si_code in siginfo_t, aligned on the pointer size.
+	const pidOffset = (12 + unsafe.Sizeof(uintptr(0)) - 1) &^ (unsafe.Sizeof(uintptr(0)) - 1)
+
+	stopped := false
+	for {
+		var info [128]byte // siginfo_t.
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPGID, uintptr(pgid), uintptr(unsafe.Pointer(&info)), syscall.WSTOPPED|syscall.WNOHANG, 0, 0)
+		if errno != 0 || *(*int32)(unsafe.Pointer(&info[pidOffset])) == 0 {
+			return stopped
+		}
+		stopped = true
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package executor
+
+// isStopped returns true if a process of the given group stopped since the last check.
+// Stopped processes are not detected on this platform.
+func isStopped(int) bool { return false }
@@ -3,6 +3,7 @@ module go.creack.net/gosh2
 go 1.24.3
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/kr/pretty v0.3.1
 	github.com/stretchr/testify v1.10.0
 )
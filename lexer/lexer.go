@@ -6,13 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
 	"unicode/utf8"
 )
 
 const variableChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
-const identifiderChars = variableChars + ",.-+*%/?^[]{}"
+const identifiderChars = variableChars + ",.-+*%/?^[]{}~"
+
+// specialParamChars are the characters that can follow '$' to form
+// a single character parameter, i.e. special and positional parameters.
+const specialParamChars = "@*#?-$!0123456789"
 
 type Lexer struct {
 	reader *bufio.Reader
@@ -100,6 +103,47 @@ func (l *Lexer) backup() {
 	}
 }
 
+// unread puts back everything read since the given position, to be read again.
+// Unlike backup, it can go back more than one rune.
+func (l *Lexer) unread(pos int) {
+	text := l.input[pos:l.pos]
+	l.reader = bufio.NewReader(io.MultiReader(strings.NewReader(text), l.reader))
+	l.atEOF = false
+	l.input = l.input[:pos]
+	l.pos = pos
+	if n := strings.Count(text, "\n"); n > 0 {
+		l.line -= n
+		lines := strings.Split(l.input, "\n")
+		l.linePos = len(lines[len(lines)-1])
+		if len(lines) > 1 {
+			l.prevLineLen = len(lines[len(lines)-2]) + 1
+		}
+	} else {
+		l.linePos -= len(text)
+	}
+}
+
+// Input returns the input read so far.
+func (l *Lexer) Input() string {
+	return l.input
+}
+
+// ReadLine reads the raw input up to the end of the current line, i.e. the lines of a here-document,
+// and returns it without the newline. Returns false at the end of the input.
+func (l *Lexer) ReadLine() (string, bool) {
+	var line strings.Builder
+	r := l.next()
+	if r == 0 {
+		return "", false
+	}
+	for ; r != '\n' && r != 0; r = l.next() {
+		line.WriteRune(r)
+	}
+	l.start = l.pos
+	l.startLine = l.line
+	return line.String(), true
+}
+
 func (l *Lexer) peek() rune {
 	r := l.next()
 	l.backup()
@@ -143,20 +187,12 @@ func (l *Lexer) thisToken(tt TokenType) Token {
 	if t.Type != TokSingleQuoteString {
 		t.Value = strings.ReplaceAll(t.Value, "\\\n", "")
 	}
-	// In doublequotes, only escape \\.
-	if t.Type == TokDoubleQuoteString {
-		t.Value = strings.ReplaceAll(t.Value, "\\\\", "\\")
-	}
-	// In identifiers, escape everything.
-	if t.Type == TokIdentifier {
-		t.Value = escapeRe.ReplaceAllString(t.Value, "$1")
-	}
+	// NOTE: Other backslashes are kept, quoting the next character,
+	// and removed when the word is expanded.
 
 	return t
 }
 
-var escapeRe = regexp.MustCompile(`\\(.)`)
-
 func (l *Lexer) emitToken(t Token) stateFn {
 	l.curToken = t
 	return nil
@@ -138,16 +138,23 @@ func lexRedirect(l *Lexer) stateFn {
 
 func lexDollar(l *Lexer) stateFn {
 	l.accept("$")
-	switch l.peek() {
-	case '$':
+	switch r := l.peek(); {
+	case strings.ContainsRune(specialParamChars, r):
+		// Special parameters and positional parameters are single characters.
 		l.next()
 		return l.emit(TokVar)
-	case '(':
+	case r == '(':
 		l.next()
+		// NOTE: $(( starts an arithmetic expansion unless the inner parenthesis
+		// isn't closed by )), see lexArithExpansion.
+		if l.peek() == '(' {
+			l.next()
+			return lexArithExpansion
+		}
 		return l.emit(TokCmdSubstitution)
-	case '{':
+	case r == '{':
 		l.next()
-		return l.emit(TokBraceLeft)
+		return lexParamExpansion
 	}
 	if !l.acceptRun(variableChars) {
 		// Case for lone '$', make it an identifier.
@@ -156,6 +163,100 @@ func lexDollar(l *Lexer) stateFn {
 	return l.emit(TokVar)
 }
 
+// lexParamExpansion lexes the whole ${...} expression, up to the matching closing brace.
+// The content is parsed later on as it depends on the operator.
+func lexParamExpansion(l *Lexer) stateFn {
+	depth := 1
+	var prev rune
+	for depth > 0 {
+		r := l.next()
+		switch {
+		case r == 0:
+			return l.errorf("unclosed %q", "${")
+		case r == '\\':
+			l.next() // Skip the escaped character.
+		case r == '\'':
+			for r = l.next(); r != '\'' && r != 0; r = l.next() {
+			}
+		case r == '"':
+			for r = l.next(); r != '"' && r != 0; r = l.next() {
+				if r == '\\' {
+					l.next()
+				}
+			}
+		case r == '{' && prev == '$':
+			depth++
+		case r == '}':
+			depth--
+		}
+		prev = r
+	}
+	return l.emit(TokParamExpansion)
+}
+
+// lexArithExpansion lexes the whole $((...)) expression, up to the matching closing parentheses.
+// The expression is parsed later on, once expanded. When the inner parenthesis is not
+// followed by the closing one, it is a command substitution starting with a subshell,
+// i.e. $((cmd1); (cmd2)), lexed again from the inner parenthesis.
+func lexArithExpansion(l *Lexer) stateFn {
+	depth := 0
+	for {
+		r := l.next()
+		switch {
+		case r == 0:
+			return l.errorf("unclosed %q", "$((")
+		case r == '\\':
+			l.next() // Skip the escaped character.
+		case r == '\'':
+			for r = l.next(); r != '\'' && r != 0; r = l.next() {
+			}
+		case r == '"':
+			for r = l.next(); r != '"' && r != 0; r = l.next() {
+				if r == '\\' {
+					l.next()
+				}
+			}
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == ')':
+			if l.next() != ')' {
+				l.unread(l.start + len("$("))
+				return l.emit(TokCmdSubstitution)
+			}
+			return l.emit(TokArithExpansion)
+		}
+	}
+}
+
+// skipNested skips the content of a $( or ${ expression within double quotes,
+// up to the matching closing rune, including nested quoted strings.
+func skipNested(l *Lexer, open, closing rune) {
+	depth := 1
+	for depth > 0 {
+		switch r := l.next(); r {
+		case 0:
+			return
+		case '\\':
+			l.next()
+		case '\'':
+			for r = l.next(); r != '\'' && r != 0; r = l.next() {
+			}
+		case '"':
+			for r = l.next(); r != '"' && r != 0; r = l.next() {
+				if r == '\\' {
+					l.next()
+				}
+			}
+		case open:
+			depth++
+		case closing:
+			depth--
+		}
+	}
+}
+
 func lexString(kind rune) stateFn {
 	return func(l *Lexer) stateFn {
 		l.accept(string(kind))
@@ -168,8 +269,25 @@ func lexString(kind rune) stateFn {
 			if r == kind {
 				break
 			}
-			if kind == '"' && r == '\\' { // Single quote doesn't escape.
+			if kind != '"' { // Single quote doesn't escape nor substitute.
+				continue
+			}
+			switch {
+			case r == '\\':
+				l.next()
+			case r == '`':
+				// Command substitutions may contain double quotes.
+				for r = l.next(); r != '`' && r != 0; r = l.next() {
+					if r == '\\' {
+						l.next()
+					}
+				}
+			case r == '$' && l.peek() == '(':
+				l.next()
+				skipNested(l, '(', ')')
+			case r == '$' && l.peek() == '{':
 				l.next()
+				skipNested(l, '{', '}')
 			}
 		}
 		tokType := TokSingleQuoteString
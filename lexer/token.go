@@ -48,6 +48,8 @@ const (
 	TokBacktick
 
 	TokCmdSubstitution // $(
+	TokParamExpansion  // ${...}
+	TokArithExpansion  // $((...))
 	TokParenLeft
 	TokParenRight
 	TokBraceLeft
@@ -57,6 +59,9 @@ const (
 
 	// End of tokens.
 	FinalToken
+
+	// TokWord is not emitted by the lexer: the parser aggregates the adjacent word tokens into words.
+	TokWord
 )
 
 var TokSeparatorOp = []TokenType{
@@ -119,12 +124,16 @@ var tokenTypeStrings = map[TokenType]string{
 	TokBacktick:        "BACKTICK",
 
 	TokCmdSubstitution: "CMD_SUBSTITUTION",
+	TokParamExpansion:  "PARAM_EXPANSION",
+	TokArithExpansion:  "ARITH_EXPANSION",
 	TokParenLeft:       "PAREN_LEFT",
 	TokParenRight:      "PAREN_RIGHT",
 	TokBraceLeft:       "BRACE_LEFT",
 	TokBraceRight:      "BRACE_RIGHT",
 	TokBracketLeft:     "BRACKET_LEFT",
 	TokBracketRight:    "BRACKET_RIGHT",
+
+	TokWord: "WORD",
 }
 
 func (tt TokenType) IsOneOf(t ...TokenType) bool {
@@ -153,8 +162,8 @@ func (t Token) PrettyPrint() string {
 		return fmt.Sprintf("'%s'", t.Value)
 	case TokDoubleQuoteString:
 		return fmt.Sprintf("\"%s\"", t.Value)
-	case TokVar:
-		return fmt.Sprintf("$%s", t.Value)
+	case TokVar, TokParamExpansion, TokArithExpansion:
+		return t.Value
 
 	case TokBang:
 		return "!"
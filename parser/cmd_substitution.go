@@ -1,39 +1,16 @@
 package parser
 
 import (
-	"bytes"
-	"errors"
-	"io"
-	"log"
-	"os"
-	"os/exec"
+	"fmt"
 	"strings"
 
+	"go.creack.net/gosh2/ast"
 	"go.creack.net/gosh2/lexer"
 )
 
-func runCommandSubstitution(input string, stderr io.Writer) lexer.Token {
-	cmd := exec.Command(os.Args[0], "-sub", "-c", input)
-
-	buf := bytes.NewBuffer(nil)
-	cmd.Stdin = nil
-	cmd.Stdout = buf
-	cmd.Stderr = stderr
-
-	if err := cmd.Run(); err != nil {
-		var e0 *exec.ExitError
-		if !errors.As(err, &e0) {
-			log.Printf("Run sub command error: %s.", err)
-		}
-	}
-
-	return lexer.Token{
-		Type:  lexer.TokIdentifier,
-		Value: strings.ReplaceAll(strings.TrimRight(buf.String(), "\n"), "\n", " "),
-	}
-}
-
-func (p *parser) evalBacktick() lexer.Token {
+// parseBacktick parses the `cmd` command substitution starting at the current token.
+// The command is only run when the word is expanded.
+func (p *parser) parseBacktick() *ast.CmdSubstitution {
 	var values []string
 
 	p.expect(lexer.TokBacktick)
@@ -44,11 +21,12 @@ func (p *parser) evalBacktick() lexer.Token {
 	}
 	p.expect(lexer.TokBacktick)
 
-	str := strings.Join(values, "")
-	return runCommandSubstitution(str, p.stderr)
+	return &ast.CmdSubstitution{Cmd: strings.Join(values, ""), Backtick: true}
 }
 
-func (p *parser) evalCommandSubstitution() lexer.Token {
+// parseCommandSubstitution parses the $(cmd) command substitution starting at the current token.
+// The command is only run when the word is expanded.
+func (p *parser) parseCommandSubstitution() *ast.CmdSubstitution {
 	var values []string
 
 	p.curToken = p.lex.NextToken()
@@ -66,6 +44,54 @@ func (p *parser) evalCommandSubstitution() lexer.Token {
 		values = append(values, p.curToken.PrettyPrint())
 		p.curToken = p.lex.NextToken()
 	}
-	str := strings.Join(values, "")
-	return runCommandSubstitution(str, p.stderr)
+	p.expect(lexer.TokParenRight)
+
+	return &ast.CmdSubstitution{Cmd: strings.Join(values, "")}
+}
+
+// closingParen returns the index of the parenthesis closing the $( expression
+// the given string starts within. Returns -1 if not found.
+func closingParen(in string) int {
+	depth := 1
+	for i := 0; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(in[i+1:], '\'')
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '"':
+			end := closingDoubleQuote(in[i+1:])
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// scanBacktick parses the `cmd` command substitution at the start of the given string.
+// Returns the part and the number of bytes consumed. The command is kept as is,
+// the backslashes being removed when run.
+func scanBacktick(in string) (*ast.CmdSubstitution, int) {
+	for i := 1; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '`':
+			return &ast.CmdSubstitution{Cmd: in[1:i], Backtick: true}, i + 1
+		}
+	}
+	panic(fmt.Errorf("unclosed %q", '`'))
 }
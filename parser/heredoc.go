@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"strings"
+
+	"go.creack.net/gosh2/ast"
+)
+
+// hereDoc is a here-document redirection, with its lines still to read.
+type hereDoc struct {
+	file      *ast.IOFile // Redirection target, getting the lines as filename.
+	delim     string      // Delimiter, with the quotes removed.
+	quoted    bool        // True if any part of the delimiter is quoted, the lines being taken as is.
+	stripTabs bool        // True for <<-, removing the leading tabs of the lines.
+}
+
+// readHereDocs reads the lines of the pending here-documents, in order, from the raw input
+// following the current newline token. With an unquoted delimiter, the lines are expanded
+// like within double quotes, otherwise they are taken as is.
+func (p *parser) readHereDocs() {
+	for _, doc := range p.hereDocs {
+		var lines strings.Builder
+		for {
+			line, ok := p.lex.ReadLine()
+			if doc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if !ok || line == doc.delim {
+				break
+			}
+			lines.WriteString(line + "\n")
+		}
+		var body ast.WordPart = &ast.SingleQuoted{Value: lines.String()}
+		if !doc.quoted {
+			body = &ast.DoubleQuoted{Parts: parseHereDoc(lines.String())}
+		}
+		doc.file.Filename = ast.Word{body}
+	}
+	p.hereDocs = nil
+}
+
+// hereDocDelimiter returns the delimiter of a here-document, with the quotes removed,
+// given its source, and whether any part of it was quoted.
+func hereDocDelimiter(in string) (string, bool) {
+	var delim strings.Builder
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == '\\' && i+1 < len(in):
+			delim.WriteByte(in[i+1])
+			i++
+		case c == '\'' || c == '"':
+			// Quotes are removed.
+		default:
+			delim.WriteByte(c)
+		}
+	}
+	return delim.String(), strings.ContainsAny(in, `\'"`)
+}
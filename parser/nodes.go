@@ -47,9 +47,14 @@ func parseList(p *parser, sep lexer.TokenType, parent *ast.List) *ast.List {
 }
 
 func parseAndOr(p *parser, sep lexer.TokenType, parent *ast.AndOr) *ast.AndOr {
+	// A linebreak is allowed after AND_IF and OR_IF.
+	if sep != 0 {
+		p.ignoreNLWhitespaces()
+	}
 	p.ignoreWhitespaces()
 
-	if p.curToken.Type == lexer.TokEOF {
+	// A list may end with a separator, i.e. `cmd &` or `cmd;` followed by a newline.
+	if p.curToken.Type.IsOneOf(lexer.TokEOF, lexer.TokNewline) || p.atListEnd() {
 		return nil
 	}
 
@@ -75,8 +80,8 @@ func parsePipeline(p *parser) *ast.Pipeline {
 
 	pipeline := &ast.Pipeline{}
 
-	// Check for negation at the start of the pipeline.
-	if p.curToken.Type == lexer.TokBang {
+	// Check for negation at the start of the pipeline, i.e. a lone unquoted `!`.
+	if lit, ok := p.curWord.Lit(); ok && p.curToken.Type == TokWord && lit == "!" {
 		pipeline.Negated = true
 		p.nextToken()
 		p.expect(lexer.TokWhitespace)
@@ -109,20 +114,69 @@ func parsePipelineSequence(p *parser, parent *ast.PipelineSequence) *ast.Pipelin
 func parseCommand(p *parser) ast.Command {
 	p.ignoreWhitespaces()
 
-	switch p.curToken.Type {
-	case lexer.TokParenLeft:
+	switch {
+	case p.isCompoundCommandStart():
 		return parseCompoundCommand(p)
 	default:
-		return parseSimpleCommand(p)
+		simpleCmd := parseSimpleCommand(p)
+		// A lone name followed by a parenthesis is a function definition.
+		if p.curToken.Type == lexer.TokParenLeft && simpleCmd.Prefix == nil && simpleCmd.Suffix == nil {
+			return parseFunctionDefinition(p, simpleCmd.Name)
+		}
+		return simpleCmd
+	}
+}
+
+// isCompoundCommandStart checks if the current token starts a compound command.
+func (p *parser) isCompoundCommandStart() bool {
+	return p.curToken.Type == lexer.TokParenLeft || p.isReservedWord("{", "if", "while", "until", "for", "case")
+}
+
+func parseFunctionDefinition(p *parser, name ast.Word) *ast.FunctionDefinition {
+	fname, ok := name.Lit()
+	if !ok || !isName(fname) {
+		panic(fmt.Errorf("invalid function name %q", name.Dump()))
+	}
+	p.nextToken() // Consume the left parenthesis.
+	p.ignoreWhitespaces()
+	p.expect(lexer.TokParenRight)
+	p.nextToken() // Consume the right parenthesis.
+	p.ignoreNLWhitespaces()
+
+	if !p.isCompoundCommandStart() {
+		panic(fmt.Errorf("expected compound command for function %q but got %s", fname, p.curToken))
+	}
+	return &ast.FunctionDefinition{
+		Name: fname,
+		Body: parseCompoundCommand(p),
 	}
 }
 
 func parseCompoundCommand(p *parser) *ast.CompoundCommandWrap {
 	compoundCmd := &ast.CompoundCommandWrap{}
 
-	switch p.curToken.Type {
-	case lexer.TokParenLeft:
+	switch {
+	case p.curToken.Type == lexer.TokParenLeft:
 		compoundCmd.CompoundCommand = parseSubshell(p)
+	case p.isReservedWord("{"):
+		p.nextToken() // Consume the left brace.
+		compoundCmd.CompoundCommand = &ast.BraceGroup{Right: parseCompoundList(p)}
+		p.expectReservedWord("}")
+		p.nextToken() // Consume the right brace.
+	case p.isReservedWord("if"):
+		compoundCmd.CompoundCommand = parseIfClause(p)
+	case p.isReservedWord("for"):
+		compoundCmd.CompoundCommand = parseForClause(p)
+	case p.isReservedWord("case"):
+		compoundCmd.CompoundCommand = parseCaseClause(p)
+	case p.isReservedWord("while"):
+		p.nextToken() // Consume the while.
+		condition := parseCompoundList(p)
+		compoundCmd.CompoundCommand = &ast.WhileClause{Condition: condition, Body: parseDoGroup(p)}
+	case p.isReservedWord("until"):
+		p.nextToken() // Consume the until.
+		condition := parseCompoundList(p)
+		compoundCmd.CompoundCommand = &ast.UntilClause{Condition: condition, Body: parseDoGroup(p)}
 	default:
 		// Cannot happen.
 		panic(fmt.Errorf("unexpected token %q", p.curToken))
@@ -132,7 +186,7 @@ func parseCompoundCommand(p *parser) *ast.CompoundCommandWrap {
 	for p.curToken.Type.IsOneOf(lexer.TokAnyRedirect...) {
 		red := parseIORedirect(p)
 		compoundCmd.Redir = append(compoundCmd.Redir, *red)
-		//p.ignoreWhitespaces()
+		p.ignoreWhitespaces()
 	}
 
 	return compoundCmd
@@ -152,8 +206,145 @@ func parseSubshell(p *parser) *ast.SubshellCommand {
 	return subshell
 }
 
-func parseCompoundList(p *parser) *ast.CompoundList {
+func parseIfClause(p *parser) *ast.IfClause {
+	p.nextToken() // Consume the if.
+
+	ifClause := &ast.IfClause{
+		Condition: parseCompoundList(p),
+	}
+	p.expectReservedWord("then")
+	p.nextToken() // Consume the then.
+	ifClause.Body = parseCompoundList(p)
+	ifClause.Else = parseElsePart(p)
+	p.expectReservedWord("fi")
+	p.nextToken() // Consume the fi.
+
+	return ifClause
+}
+
+func parseElsePart(p *parser) *ast.ElsePart {
+	switch {
+	case p.isReservedWord("elif"):
+		p.nextToken() // Consume the elif.
+		elsePart := &ast.ElsePart{
+			Condition: parseCompoundList(p),
+		}
+		p.expectReservedWord("then")
+		p.nextToken() // Consume the then.
+		elsePart.Body = parseCompoundList(p)
+		elsePart.Else = parseElsePart(p)
+		return elsePart
+	case p.isReservedWord("else"):
+		p.nextToken() // Consume the else.
+		return &ast.ElsePart{
+			Body: parseCompoundList(p),
+		}
+	default:
+		return nil
+	}
+}
+
+func parseForClause(p *parser) *ast.ForClause {
+	p.nextToken() // Consume the for.
+	p.ignoreWhitespaces()
+
+	name, ok := p.expectWord().Lit()
+	if !ok || !isName(name) {
+		panic(fmt.Errorf("invalid for loop variable %s", p.curToken))
+	}
+	p.nextToken() // Consume the name.
+	forClause := &ast.ForClause{Name: name}
+
 	p.ignoreWhitespaces()
+	if p.curToken.Type == lexer.TokSemicolon {
+		p.nextToken() // Consume the semicolon.
+		forClause.Body = parseDoGroup(p)
+		return forClause
+	}
+	p.ignoreNLWhitespaces()
+	if p.isReservedWord("in") {
+		forClause.In = true
+		p.nextToken() // Consume the in.
+		p.ignoreWhitespaces()
+		for p.curToken.Type == TokWord {
+			forClause.Words = append(forClause.Words, p.curWord)
+			p.nextToken()
+			p.ignoreWhitespaces()
+		}
+		p.expect(lexer.TokSemicolon, lexer.TokNewline)
+		p.nextToken() // Consume the separator.
+	}
+	forClause.Body = parseDoGroup(p)
+
+	return forClause
+}
+
+func parseCaseClause(p *parser) *ast.CaseClause {
+	p.nextToken() // Consume the case.
+	p.ignoreWhitespaces()
+	caseClause := &ast.CaseClause{Word: p.expectWord()}
+	p.nextToken() // Consume the word.
+	p.ignoreNLWhitespaces()
+	p.expectReservedWord("in")
+	p.nextToken() // Consume the in.
+	p.ignoreNLWhitespaces()
+
+	for !p.isReservedWord("esac") {
+		caseClause.Items = append(caseClause.Items, parseCaseItem(p))
+	}
+	p.nextToken() // Consume the esac.
+
+	return caseClause
+}
+
+func parseCaseItem(p *parser) ast.CaseItem {
+	if p.curToken.Type == lexer.TokParenLeft {
+		p.nextToken() // Consume the optional left parenthesis.
+		p.ignoreWhitespaces()
+	}
+
+	var item ast.CaseItem
+	for {
+		item.Patterns = append(item.Patterns, p.expectWord())
+		p.nextToken() // Consume the pattern.
+		p.ignoreWhitespaces()
+		if p.curToken.Type != lexer.TokPipe {
+			break
+		}
+		p.nextToken() // Consume the pipe.
+		p.ignoreWhitespaces()
+	}
+	p.expect(lexer.TokParenRight)
+	p.nextToken() // Consume the right parenthesis.
+	p.ignoreNLWhitespaces()
+
+	if p.curToken.Type != lexer.TokDoubleSemicolon && !p.isReservedWord("esac") {
+		item.Body = parseCompoundList(p)
+	}
+	// The last item may omit the double semicolon.
+	if p.curToken.Type == lexer.TokDoubleSemicolon {
+		p.nextToken() // Consume the double semicolon.
+		p.ignoreNLWhitespaces()
+	} else {
+		p.expectReservedWord("esac")
+	}
+
+	return item
+}
+
+// parseDoGroup parses `do compound_list done`, returning the list.
+func parseDoGroup(p *parser) *ast.CompoundList {
+	p.ignoreNLWhitespaces()
+	p.expectReservedWord("do")
+	p.nextToken() // Consume the do.
+	body := parseCompoundList(p)
+	p.expectReservedWord("done")
+	p.nextToken() // Consume the done.
+	return body
+}
+
+func parseCompoundList(p *parser) *ast.CompoundList {
+	p.ignoreNLWhitespaces()
 
 	cList := &ast.CompoundList{
 		Term: parseTerm(p, 0, nil),
@@ -162,12 +353,16 @@ func parseCompoundList(p *parser) *ast.CompoundList {
 		cList.Separator = cList.Term.Separator
 		cList.Term = cList.Term.Left
 	}
+	if cList.Term == nil {
+		panic(fmt.Errorf("unexpected token %s", p.curToken))
+	}
 
 	return cList
 }
 
 func parseTerm(p *parser, sep lexer.TokenType, parent *ast.Term) *ast.Term {
-	p.ignoreWhitespaces()
+	// Within compound lists, newlines are separators like semicolons.
+	p.ignoreNLWhitespaces()
 
 	term := &ast.Term{
 		Left:      parent,
@@ -193,8 +388,11 @@ func parseSimpleCommand(p *parser) *ast.SimpleCommand {
 	simpleCmd.Prefix = parseCmdPrefix(p, nil)
 
 	// Handle the command name.
-	// TODO: Add support for `e"c"h'o' hello world`.
-	simpleCmd.Name = p.expectIdentifierStr().Value
+	// A command can be only made of prefixes, i.e. `a=b` or `>foo`.
+	if simpleCmd.Prefix != nil && p.curToken.Type != TokWord {
+		return simpleCmd
+	}
+	simpleCmd.Name = p.expectWord()
 	p.nextToken()
 	p.ignoreWhitespaces()
 
@@ -207,15 +405,16 @@ func parseSimpleCommand(p *parser) *ast.SimpleCommand {
 func parseCmdPrefix(p *parser, parent *ast.CmdPrefix) *ast.CmdPrefix {
 	p.ignoreWhitespaces()
 
-	if p.peek().Type == lexer.TokEquals {
+	if p.curToken.Type == TokWord {
+		assignment, ok := parseAssignment(p.curWord)
+		if !ok {
+			return parent
+		}
 		prefix := &ast.CmdPrefix{
 			Left:           parent,
-			AssignmentWord: p.expectIdentifierStr().Value,
+			AssignmentWord: assignment,
 		}
-		p.nextToken() // Consume the variable name.
-		p.nextToken() // Consume the equals sign.
-		prefix.AssignmentWord += "=" + p.expectIdentifierStr().Value
-		p.nextToken() // Consume the variable value.
+		p.nextToken() // Consume the assignment word.
 		return parseCmdPrefix(p, prefix)
 	}
 	if p.curToken.Type.IsOneOf(lexer.TokAnyRedirect...) {
@@ -235,10 +434,10 @@ func parseCmdSuffix(p *parser, parent *ast.CmdSuffix) *ast.CmdSuffix {
 	}
 	p.ignoreWhitespaces()
 
-	if p.curToken.Type.IsOneOf(lexer.TokIdentifier, lexer.TokSingleQuoteString, lexer.TokDoubleQuoteString, lexer.TokNumber) {
+	if p.curToken.Type == TokWord {
 		suffix := &ast.CmdSuffix{
 			Left: parent,
-			Word: p.expectIdentifierStr().Value,
+			Word: p.expectWord(),
 		}
 		p.nextToken()
 		return parseCmdSuffix(p, suffix)
@@ -275,11 +474,13 @@ func parseIORedirect(p *parser) *ast.IORedirect {
 			},
 		}
 
-		target := p.expectIdentifierStr().Value
-		if p.curToken.Type == lexer.TokNumber {
-			n, err := strconv.Atoi(target)
+		target := p.expectWord()
+		if lit, ok := target.Lit(); ok && lit == "-" {
+			red.IOFile.Close = true
+		} else if ok && isNumber(lit) {
+			n, err := strconv.Atoi(lit)
 			if err != nil {
-				panic(fmt.Errorf("invalid target fd number: %q", target))
+				panic(fmt.Errorf("invalid target fd number: %q", lit))
 			}
 			red.IOFile.ToNumber = &n
 		} else {
@@ -291,40 +492,31 @@ func parseIORedirect(p *parser) *ast.IORedirect {
 		p.nextToken() // Consume the target token.
 		return red
 
-	case lexer.TokRedirectLess, lexer.TokRedirectGreat, lexer.TokRedirectDoubleGreat, lexer.TokRedirectLessGreat:
+	case lexer.TokRedirectLess, lexer.TokRedirectGreat, lexer.TokRedirectDoubleGreat, lexer.TokRedirectLessGreat, lexer.TokRedirectClobber:
 		red := &ast.IORedirect{
 			Number: fd,
 			IOFile: ast.IOFile{
 				Operator: op,
-				Filename: p.expectIdentifierStr().Value,
+				Filename: p.expectWord(),
 			},
 		}
 		p.nextToken() // Consume the target token.
 		return red
 
 	case lexer.TokRedirectDoubleLess, lexer.TokRedirectDoubleLessDash:
-		hereEnd := p.expectIdentifierStr().Value
-		p.nextToken()                            // Consume the hereEnd token.
-		p.expect(lexer.TokNewline, lexer.TokEOF) // We exepect a newline token here.
-		p.nextToken()                            // Consume the newline token.
-
-		// Consume all tokens until we reach the hereEnd token.
-		hereDoc := ""
-		for !p.curToken.Type.IsOneOf(lexer.TokEOF, lexer.TokError) && p.curToken.Value != hereEnd {
-			hereDoc += p.curToken.Value
-			p.nextToken()
-		}
-		// Consume the hereEnd token.
-		if p.curToken.Value == hereEnd {
-			p.nextToken()
-		}
 		red := &ast.IORedirect{
 			Number: fd,
-			IOFile: ast.IOFile{
-				Operator: lexer.TokRedirectDoubleLess,
-				Filename: hereDoc,
-			},
+			IOFile: ast.IOFile{Operator: lexer.TokRedirectDoubleLess},
 		}
+		// The lines are read once the end of the current line is reached.
+		delim, quoted := hereDocDelimiter(p.expectWord().Dump())
+		p.hereDocs = append(p.hereDocs, &hereDoc{
+			file:      &red.IOFile,
+			delim:     delim,
+			quoted:    quoted,
+			stripTabs: op == lexer.TokRedirectDoubleLessDash,
+		})
+		p.nextToken() // Consume the hereEnd token.
 		return red
 
 	default:
@@ -332,3 +524,16 @@ func parseIORedirect(p *parser) *ast.IORedirect {
 		panic(fmt.Errorf("unsupported redirect %q", op))
 	}
 }
+
+// isNumber returns true if the given string is only made of digits.
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
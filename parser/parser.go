@@ -1,9 +1,10 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"slices"
 	"strings"
 
 	"go.creack.net/gosh2/ast"
@@ -11,44 +12,53 @@ import (
 	"go.creack.net/gosh2/lexer"
 )
 
-// TokWorkd is an evaluted token type for words.
-// TODO: Implement this.
-const TokWord lexer.TokenType = lexer.FinalToken + iota + 1
+// TokWord is an aggregated token type for words.
+// When the current token is a word, the parsed parts are in curWord.
+const TokWord = lexer.TokWord
+
+// wordTokens are the token types aggregated into words.
+var wordTokens = []lexer.TokenType{
+	lexer.TokIdentifier,
+	lexer.TokNumber,
+	lexer.TokSingleQuoteString,
+	lexer.TokDoubleQuoteString,
+	lexer.TokCmdSubstitution,
+	lexer.TokBacktick,
+	lexer.TokVar,
+	lexer.TokParamExpansion,
+	lexer.TokArithExpansion,
+	lexer.TokEquals,
+	lexer.TokBang, // Only reserved at the start of a pipeline.
+}
 
 type parser struct {
 	lex *lexer.Lexer
 
 	prevToken lexer.Token
 	curToken  lexer.Token
+	curWord   ast.Word // Parts of the current token when it is a TokWord.
 
 	peekToken *lexer.Token // Buffer.
 
-	// TODO: Reconsider this. Not a fan of having execution related fields in the parser itself.
-	stderr io.Writer // Stderr for command substitution.
+	hereDocs []*hereDoc // Here-documents with their lines still to read, after the current line.
 }
 
 type Parser interface {
 	NextCompleteCommand() *ast.CompleteCommand
 }
 
-func newParser(lex *lexer.Lexer, stderr io.Writer) *parser {
-	if stderr == nil {
-		stderr = os.Stderr
-	}
-	return &parser{
-		lex:    lex,
-		stderr: stderr,
-	}
+func newParser(lex *lexer.Lexer) *parser {
+	return &parser{lex: lex}
 }
 
-func New(r io.Reader, stderr io.Writer) Parser {
-	return newParser(lexer.New(r), stderr)
+func New(r io.Reader) Parser {
+	return newParser(lexer.New(r))
 }
 
-func Parse(lex *lexer.Lexer, stderr io.Writer) ast.Program {
+func Parse(lex *lexer.Lexer) ast.Program {
 	var cmds []ast.CompleteCommand
 
-	p := newParser(lex, stderr)
+	p := newParser(lex)
 	for {
 		cmd := p.NextCompleteCommand()
 		if cmd == nil {
@@ -61,12 +71,17 @@ func Parse(lex *lexer.Lexer, stderr io.Writer) ast.Program {
 }
 
 func RunSubshell(argv []string, exitFn func(int), stdin io.Reader, stdout, stderr io.Writer) bool {
-	// args[0] -sub -c 'cmd'
-	if len(argv) != 4 || argv[1] != "-sub" || argv[2] != "-c" {
+	// args[0] -sub 'prelude' -c 'cmd'
+	if len(argv) != 5 || argv[1] != "-sub" || argv[3] != "-c" {
 		return false
 	}
-	exitCode, err := Run(strings.NewReader(argv[3]), stdin, stdout, stderr)
-	if err != nil {
+	sh := newShell()
+	if err := sh.RestoreSubshell(argv[2], stdin, stdout, stderr); err != nil {
+		fmt.Fprintf(stderr, "gosh2: %s\n", err)
+	}
+	// The script is part of the input of the parent shell, already written with verbose.
+	exitCode, err := run(sh, strings.NewReader(argv[4]), false, stdin, stdout, stderr)
+	if err != nil && exitCode <= 0 {
 		exitFn(1)
 		return true
 	}
@@ -75,22 +90,54 @@ func RunSubshell(argv []string, exitFn func(int), stdin io.Reader, stdout, stder
 }
 
 func Run(input, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
-	p := New(input, stderr)
+	return run(newShell(), input, true, stdin, stdout, stderr)
+}
+
+// newShell returns a new shell evaluating strings with this parser.
+func newShell() *executor.Shell {
+	return executor.NewShell(func(r io.Reader) executor.Parser { return New(r) })
+}
+
+// run parses and executes the given input within the given shell.
+// When verbose is set, the input of each command is written to stderr before it runs,
+// with the verbose option.
+func run(sh *executor.Shell, input io.Reader, verbose bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	p := newParser(lexer.New(input))
+	if err := sh.SetInteractive(input); err != nil {
+		fmt.Fprintf(stderr, "gosh2: %s\n", err)
+	}
 
 	var lastExitCode int
+	var read int // Length of the input already read by the previous commands.
 	for {
-		cmd := p.NextCompleteCommand()
+		cmd, err := p.nextCompleteCommand()
+		text := p.lex.Input()[read:]
+		read += len(text)
+		if verbose && sh.Option("verbose") && text != "" {
+			// The last line may not end with a newline.
+			fmt.Fprintln(stderr, strings.TrimSuffix(text, "\n"))
+		}
+		if err != nil {
+			// As in a non-interactive shell, syntax errors stop the shell.
+			fmt.Fprintf(stderr, "gosh2: %s\n", err)
+			return sh.Exit(2, stdin, stdout, stderr), err
+		}
 		if cmd == nil {
 			break
 		}
-		exitCode, err := executor.Evaluate(*cmd, stdin, stdout, stderr)
+		exitCode, err := executor.Evaluate(sh, *cmd, stdin, stdout, stderr)
+		// The exit builtin stops the shell without error.
+		var exitErr *executor.ExitError
+		if errors.As(err, &exitErr) && exitErr.Err == nil {
+			return sh.Exit(exitErr.Code, stdin, stdout, stderr), nil
+		}
 		if err != nil {
-			return exitCode, err
+			return sh.Exit(exitCode, stdin, stdout, stderr), err
 		}
 		lastExitCode = exitCode
 	}
 
-	return lastExitCode, nil
+	return sh.Exit(lastExitCode, stdin, stdout, stderr), nil
 }
 
 func (p *parser) NextCompleteCommand() *ast.CompleteCommand {
@@ -102,16 +149,29 @@ func (p *parser) NextCompleteCommand() *ast.CompleteCommand {
 	return parseCompleteCommand(p)
 }
 
+// nextCompleteCommand returns the next complete command, reporting syntax errors as errors.
+func (p *parser) nextCompleteCommand() (cmd *ast.CompleteCommand, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("syntax error: %v", r)
+		}
+	}()
+	return p.NextCompleteCommand(), nil
+}
+
 func (p *parser) nextToken() lexer.Token {
 	p.prevToken = p.curToken
 	if p.peekToken != nil {
 		p.curToken = *p.peekToken
 		p.peekToken = nil
-		p.curToken = p.aggregateTokens()
-		return p.curToken
+	} else {
+		p.curToken = p.lex.NextToken()
 	}
-	p.curToken = p.lex.NextToken()
 	p.curToken = p.aggregateTokens()
+	// The lines of the here-documents follow the newline, which is never peeked past.
+	if p.curToken.Type == lexer.TokNewline {
+		p.readHereDocs()
+	}
 	return p.curToken
 }
 
@@ -124,44 +184,39 @@ func (p *parser) peek() lexer.Token {
 	return tok
 }
 
+// aggregateTokens merges the current token with the following
+// adjacent word tokens into a single TokWord.
 func (p *parser) aggregateTokens() lexer.Token {
-	tok := p.evalToken()
-
-	words := []lexer.TokenType{
-		lexer.TokIdentifier,
-		lexer.TokNumber,
-		lexer.TokSingleQuoteString,
-		lexer.TokDoubleQuoteString,
-		lexer.TokCmdSubstitution,
-		lexer.TokBacktick,
+	if !p.curToken.Type.IsOneOf(wordTokens...) {
+		p.curWord = nil
+		return p.curToken
 	}
 
-	if !tok.Type.IsOneOf(words...) {
-		return tok
-	}
-	for p.peek().Type.IsOneOf(words...) {
-		ntok := p.nextToken()
-		tok.Value += ntok.Value
+	tok := p.curToken
+	word := p.appendCurrentParts(nil)
+	for p.peek().Type.IsOneOf(wordTokens...) {
+		p.curToken = *p.peekToken
+		p.peekToken = nil
+		tok.Value += p.curToken.Value
+		word = p.appendCurrentParts(word)
 	}
-	//	tok.Type = TokWord
+	tok.Type = TokWord
+	p.curWord = word
 	return tok
 }
 
-func (p *parser) evalToken() lexer.Token {
-	tok := p.curToken
-
-	switch tok.Type {
-	case lexer.TokIdentifier:
-		tok.Value = evalGlobing(tok.Value)
+// appendCurrentParts appends the parts of the current token to the word.
+// Command substitutions consume the tokens up to their end, unevaluated:
+// all the expansions are performed by the executor.
+func (p *parser) appendCurrentParts(word ast.Word) ast.Word {
+	switch p.curToken.Type {
 	case lexer.TokBacktick:
-		tok = p.evalBacktick()
+		return append(word, p.parseBacktick())
 	case lexer.TokCmdSubstitution:
-		tok = p.evalCommandSubstitution()
-	case lexer.TokDoubleQuoteString:
-		tok.Value = strings.ReplaceAll(tok.Value, "\\\"", "\"")
+		return append(word, p.parseCommandSubstitution())
+	default:
+		return appendTokenParts(word, p.curToken)
 	}
-
-	return tok
 }
 
 // expect checks if the current token is of the expected type.
@@ -169,13 +224,42 @@ func (p *parser) expect(kind ...lexer.TokenType) lexer.Token {
 	if p.curToken.Type.IsOneOf(kind...) {
 		return p.curToken
 	}
+	// Errors from the lexer are reported as is, e.g. unclosed quotes.
+	if p.curToken.Type == lexer.TokError {
+		panic(errors.New(p.curToken.Value))
+	}
 	panic(fmt.Errorf("expected token %v but got %s (%s)", kind, p.curToken.Type, p.curToken))
 }
 
-// expectIdentifierStr checks if the current token is an identifier at large,
-// i.e., it can be a raw identifier, a single/double quoted string or a number.
-func (p *parser) expectIdentifierStr() lexer.Token {
-	return p.expect(lexer.TokIdentifier, lexer.TokSingleQuoteString, lexer.TokDoubleQuoteString, lexer.TokNumber)
+// expectWord checks if the current token is a word and returns its parts.
+func (p *parser) expectWord() ast.Word {
+	p.expect(TokWord)
+	return p.curWord
+}
+
+// closingReservedWords are the reserved words ending a compound list.
+var closingReservedWords = []string{"then", "else", "elif", "fi", "do", "done", "esac", "}"}
+
+// isReservedWord checks if the current token is one of the given reserved words.
+// Reserved words are only recognized when unquoted.
+func (p *parser) isReservedWord(words ...string) bool {
+	if p.curToken.Type != TokWord {
+		return false
+	}
+	lit, ok := p.curWord.Lit()
+	return ok && slices.Contains(words, lit)
+}
+
+// expectReservedWord checks if the current token is the given reserved word.
+func (p *parser) expectReservedWord(word string) {
+	if !p.isReservedWord(word) {
+		panic(fmt.Errorf("expected %q but got %s", word, p.curToken))
+	}
+}
+
+// atListEnd checks if the current token ends a list, i.e. a closing reserved word or parenthesis.
+func (p *parser) atListEnd() bool {
+	return p.curToken.Type.IsOneOf(lexer.TokParenRight, lexer.TokDoubleSemicolon) || p.isReservedWord(closingReservedWords...)
 }
 
 func (p *parser) ignoreWhitespaces() {
@@ -0,0 +1,338 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"go.creack.net/gosh2/ast"
+	"go.creack.net/gosh2/lexer"
+)
+
+// appendLiteral appends the given literal source to the word,
+// merging it with the previous part when also a literal.
+func appendLiteral(word ast.Word, value string) ast.Word {
+	if len(word) > 0 {
+		if l, ok := word[len(word)-1].(*ast.Literal); ok {
+			l.Value += value
+			return word
+		}
+	}
+	return append(word, &ast.Literal{Value: value})
+}
+
+// appendTokenParts appends the parts of the given token to the word.
+func appendTokenParts(word ast.Word, tok lexer.Token) ast.Word {
+	switch tok.Type {
+	case lexer.TokVar:
+		return append(word, &ast.ParamExpansion{Name: strings.TrimPrefix(tok.Value, "$")})
+	case lexer.TokParamExpansion:
+		content := strings.TrimSuffix(strings.TrimPrefix(tok.Value, "${"), "}")
+		return append(word, parseParamExpansion(content, false))
+	case lexer.TokArithExpansion:
+		expr := strings.TrimSuffix(strings.TrimPrefix(tok.Value, "$(("), "))")
+		return append(word, &ast.ArithExpansion{Expr: parseDoubleQuoted(expr)})
+	case lexer.TokSingleQuoteString:
+		return append(word, &ast.SingleQuoted{Value: tok.Value})
+	case lexer.TokDoubleQuoteString:
+		return append(word, &ast.DoubleQuoted{Parts: parseDoubleQuoted(tok.Value)})
+	default:
+		return appendLiteral(word, tok.Value)
+	}
+}
+
+// parseDoubleQuoted parses the content of a double quoted string
+// into literals, parameter expansions and command substitutions.
+// Backslashes are kept in the literals, the quotes being removed on expansion.
+func parseDoubleQuoted(in string) ast.Word {
+	var word ast.Word
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == '\\' && i+1 < len(in):
+			word = appendLiteral(word, in[i:i+2])
+			i++
+		case c == '$':
+			part, n := scanDollar(in[i:], true)
+			if part == nil {
+				word = appendLiteral(word, "$")
+				continue
+			}
+			word = append(word, part)
+			i += n - 1
+		case c == '`':
+			part, n := scanBacktick(in[i:])
+			word = append(word, part)
+			i += n - 1
+		case c == '"':
+			// Only within the operand of ${name:-word}, where quotes nest.
+			end := closingDoubleQuote(in[i+1:])
+			if end == -1 {
+				panic(fmt.Errorf("unclosed %q", c))
+			}
+			word = append(word, &ast.DoubleQuoted{Parts: parseDoubleQuoted(in[i+1 : i+1+end])})
+			i += end + 1
+		default:
+			word = appendLiteral(word, in[i:i+1])
+		}
+	}
+	return word
+}
+
+// parseHereDoc parses the lines of a here-document with an unquoted delimiter, expanded
+// like within double quotes, except double quotes are not special: a backslash before one is kept.
+func parseHereDoc(in string) ast.Word {
+	var word ast.Word
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == '\\' && i+1 < len(in) && in[i+1] == '"':
+			word = appendLiteral(word, `\\"`)
+			i++
+		case c == '\\' && i+1 < len(in):
+			word = appendLiteral(word, in[i:i+2])
+			i++
+		case c == '$':
+			part, n := scanDollar(in[i:], true)
+			if part == nil {
+				word = appendLiteral(word, "$")
+				continue
+			}
+			word = append(word, part)
+			i += n - 1
+		case c == '`':
+			part, n := scanBacktick(in[i:])
+			word = append(word, part)
+			i += n - 1
+		default:
+			word = appendLiteral(word, in[i:i+1])
+		}
+	}
+	return word
+}
+
+// parseUnquotedWord parses the given string as an unquoted word
+// where blanks are not delimiters, i.e. the operand of ${name:-word}.
+func parseUnquotedWord(in string) ast.Word {
+	var word ast.Word
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == '\\' && i+1 < len(in):
+			word = appendLiteral(word, in[i:i+2])
+			i++
+		case c == '\'':
+			end := strings.IndexByte(in[i+1:], '\'')
+			if end == -1 {
+				panic(fmt.Errorf("unclosed %q", c))
+			}
+			word = append(word, &ast.SingleQuoted{Value: in[i+1 : i+1+end]})
+			i += end + 1
+		case c == '"':
+			end := closingDoubleQuote(in[i+1:])
+			if end == -1 {
+				panic(fmt.Errorf("unclosed %q", c))
+			}
+			word = append(word, &ast.DoubleQuoted{Parts: parseDoubleQuoted(in[i+1 : i+1+end])})
+			i += end + 1
+		case c == '$':
+			part, n := scanDollar(in[i:], false)
+			if part == nil {
+				word = appendLiteral(word, "$")
+				continue
+			}
+			word = append(word, part)
+			i += n - 1
+		case c == '`':
+			part, n := scanBacktick(in[i:])
+			word = append(word, part)
+			i += n - 1
+		default:
+			word = appendLiteral(word, in[i:i+1])
+		}
+	}
+	return word
+}
+
+// closingDoubleQuote returns the index of the unescaped double quote ending the given string.
+// Returns -1 if not found.
+func closingDoubleQuote(in string) int {
+	for i := 0; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// closingBrace returns the index of the brace closing the ${ expression
+// the given string starts within. Returns -1 if not found.
+func closingBrace(in string) int {
+	depth := 1
+	for i := 0; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(in[i+1:], '\'')
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '"':
+			end := closingDoubleQuote(in[i+1:])
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '{':
+			if i > 0 && in[i-1] == '$' {
+				depth++
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// scanDollar parses the expansion at the start of the given string, starting with '$'.
+// Returns the part and the number of bytes consumed, or nil if it is a lone '$'.
+func scanDollar(in string, quoted bool) (ast.WordPart, int) {
+	if strings.HasPrefix(in, "$((") {
+		// The parenthesis closing the inner one must be followed by the closing one,
+		// otherwise it is a command substitution starting with a subshell, i.e. $((cmd1); (cmd2)).
+		if end := closingParen(in[3:]); end != -1 && 3+end+1 < len(in) && in[3+end+1] == ')' {
+			return &ast.ArithExpansion{Expr: parseDoubleQuoted(in[3 : 3+end])}, end + 5
+		}
+	}
+	if strings.HasPrefix(in, "$(") {
+		end := closingParen(in[2:])
+		if end == -1 {
+			panic(fmt.Errorf("unclosed %q", "$("))
+		}
+		return &ast.CmdSubstitution{Cmd: in[2 : 2+end]}, end + 3
+	}
+	if strings.HasPrefix(in, "${") {
+		end := closingBrace(in[2:])
+		if end == -1 {
+			panic(fmt.Errorf("unclosed %q", "${"))
+		}
+		return parseParamExpansion(in[2:2+end], quoted), end + 3
+	}
+	name := paramName(in[1:])
+	if name == "" {
+		return nil, 0
+	}
+	return &ast.ParamExpansion{Name: name}, len(name) + 1
+}
+
+// paramExpansionOps is the list of operators supported in ${name<op>word}.
+// NOTE: Order matters as the first matching prefix wins.
+var paramExpansionOps = []string{":-", ":=", ":?", ":+", "-", "=", "?", "+", "##", "#", "%%", "%"}
+
+// parseParamExpansion parses the content of a ${...} expression.
+func parseParamExpansion(content string, quoted bool) *ast.ParamExpansion {
+	pe := &ast.ParamExpansion{}
+
+	// ${#name} is the length of the parameter, while ${#} is the number of positional parameters.
+	if len(content) > 1 && content[0] == '#' {
+		pe.Length = true
+		content = content[1:]
+	}
+
+	pe.Name = paramName(content)
+	// Within braces, positional parameters can have more than one digit.
+	if pe.Name != "" && isNumber(pe.Name) {
+		i := 0
+		for i < len(content) && content[i] >= '0' && content[i] <= '9' {
+			i++
+		}
+		pe.Name = content[:i]
+	}
+	if pe.Name == "" {
+		panic(fmt.Errorf("bad substitution: %q", "${"+content+"}"))
+	}
+	rest := content[len(pe.Name):]
+	if rest == "" {
+		return pe
+	}
+	if pe.Length {
+		panic(fmt.Errorf("bad substitution: %q", "${#"+content+"}"))
+	}
+
+	for _, op := range paramExpansionOps {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		pe.Op = op
+		operand := rest[len(op):]
+		// Enclosing double quotes don't apply to patterns.
+		if quoted && !strings.ContainsAny(op, "#%") {
+			pe.Word = parseDoubleQuoted(operand)
+		} else {
+			pe.Word = parseUnquotedWord(operand)
+		}
+		return pe
+	}
+	panic(fmt.Errorf("bad substitution: %q", "${"+content+"}"))
+}
+
+// paramName returns the parameter name at the start of the given string.
+// Returns an empty string if there is none.
+func paramName(in string) string {
+	if in == "" {
+		return ""
+	}
+	if strings.IndexByte("@*#?-$!0123456789", in[0]) != -1 {
+		return in[:1]
+	}
+	i := 0
+	for i < len(in) && isNameChar(in[i], i == 0) {
+		i++
+	}
+	return in[:i]
+}
+
+// isNameChar returns true if the given byte is valid in a variable name.
+// Names can't start with a digit.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// isName returns true if the given string is a valid variable name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if !isNameChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAssignment checks if the given word is an assignment word, i.e. name=value
+// with an unquoted name, and returns it.
+func parseAssignment(word ast.Word) (*ast.Assignment, bool) {
+	if len(word) == 0 {
+		return nil, false
+	}
+	l, ok := word[0].(*ast.Literal)
+	if !ok {
+		return nil, false
+	}
+	name, value, found := strings.Cut(l.Value, "=")
+	if !found || !isName(name) {
+		return nil, false
+	}
+	a := &ast.Assignment{Name: name}
+	if value != "" {
+		a.Value = append(a.Value, &ast.Literal{Value: value})
+	}
+	a.Value = append(a.Value, word[1:]...)
+	return a, true
+}